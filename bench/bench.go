@@ -16,8 +16,8 @@ type Bench struct {
 	client *clientlib.Client
 }
 
-func NewBench(num, size int32) (*Bench, error) {
-	client, err := clientlib.NewClient()
+func NewBench(num, size int32, opts ...clientlib.Option) (*Bench, error) {
+	client, err := clientlib.NewClient(opts...)
 	if err != nil {
 		return nil, err
 	}
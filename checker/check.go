@@ -0,0 +1,223 @@
+package checker
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Names of the checks performed on a history.
+const (
+	// CheckUniqueGsn verifies that no two appends returned the same global
+	// sequence number.
+	CheckUniqueGsn = "unique-gsn"
+	// CheckAgreement verifies that appends, reads and deliveries agree on the
+	// record with each global sequence number.
+	CheckAgreement = "agreement"
+	// CheckAppendOrder verifies that each client's appends are assigned
+	// increasing global sequence numbers in the order they completed.
+	CheckAppendOrder = "append-order"
+	// CheckGapFree verifies that each subscription delivers consecutive global
+	// sequence numbers starting from the one subscribed to.
+	CheckGapFree = "gap-free"
+	// CheckTrim verifies that records are reported trimmed only after a trim
+	// covering them was invoked.
+	CheckTrim = "trim"
+)
+
+// Violation is an inconsistency found in a history.
+type Violation struct {
+	// Name of the check that found the violation
+	Check string
+	// Global sequence number involved in the violation
+	Gsn int32
+	// Description of the violation
+	Message string
+}
+
+// String returns a description of the violation.
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: gsn %d: %s", v.Check, v.Gsn, v.Message)
+}
+
+// CheckFile reads a history from a JSON file and checks it.
+func CheckFile(path string) ([]Violation, error) {
+	history, err := ReadHistory(path)
+	if err != nil {
+		return nil, err
+	}
+	return Check(history), nil
+}
+
+// Check verifies that a history is consistent with a totally ordered shared
+// log, and returns the violations found.
+func Check(history []Operation) []Violation {
+	var violations []Violation
+	violations = append(violations, checkUniqueGsn(history)...)
+	violations = append(violations, checkAgreement(history)...)
+	violations = append(violations, checkAppendOrder(history)...)
+	violations = append(violations, checkGapFree(history)...)
+	violations = append(violations, checkTrim(history)...)
+	return violations
+}
+
+// checkUniqueGsn verifies that no two successful appends returned the same
+// global sequence number.
+func checkUniqueGsn(history []Operation) []Violation {
+	var violations []Violation
+	appends := make(map[int32]Operation)
+	for _, op := range history {
+		if op.Op != OpAppend || op.Result != ResultOK {
+			continue
+		}
+		if prev, in := appends[op.Gsn]; in {
+			violations = append(violations, Violation{
+				Check:   CheckUniqueGsn,
+				Gsn:     op.Gsn,
+				Message: fmt.Sprintf("assigned to %q by client %d and %q by client %d", prev.Record, prev.Client, op.Record, op.Client),
+			})
+			continue
+		}
+		appends[op.Gsn] = op
+	}
+	return violations
+}
+
+// checkAgreement verifies that successful reads and deliveries of a global
+// sequence number return the record appended with it, and agree with each
+// other when the append is not in the history.
+func checkAgreement(history []Operation) []Violation {
+	var violations []Violation
+	records := make(map[int32]Operation)
+	for _, op := range history {
+		if op.Op == OpAppend && op.Result == ResultOK {
+			records[op.Gsn] = op
+		}
+	}
+	for _, op := range history {
+		if (op.Op != OpRead && op.Op != OpDeliver) || op.Result != ResultOK {
+			continue
+		}
+		expected, in := records[op.Gsn]
+		if !in {
+			records[op.Gsn] = op
+			continue
+		}
+		if op.Record != expected.Record {
+			violations = append(violations, Violation{
+				Check:   CheckAgreement,
+				Gsn:     op.Gsn,
+				Message: fmt.Sprintf("%s by client %d returned %q, but %s by client %d returned %q", op.Op, op.Client, op.Record, expected.Op, expected.Client, expected.Record),
+			})
+		}
+	}
+	return violations
+}
+
+// checkAppendOrder verifies that an append of a client that completed before
+// another append of the same client was invoked has a smaller global sequence
+// number.
+func checkAppendOrder(history []Operation) []Violation {
+	var violations []Violation
+	appends := make(map[int][]Operation)
+	for _, op := range history {
+		if op.Op == OpAppend && op.Result == ResultOK {
+			appends[op.Client] = append(appends[op.Client], op)
+		}
+	}
+	for client, ops := range appends {
+		byInvoke := make([]Operation, len(ops))
+		copy(byInvoke, ops)
+		sort.Slice(byInvoke, func(i, j int) bool {
+			return byInvoke[i].Invoke.Before(byInvoke[j].Invoke)
+		})
+		byComplete := ops
+		sort.Slice(byComplete, func(i, j int) bool {
+			return byComplete[i].Complete.Before(byComplete[j].Complete)
+		})
+		// Largest global sequence number of the appends completed before the
+		// current append was invoked
+		var prev *Operation
+		next := 0
+		for _, op := range byInvoke {
+			for ; next < len(byComplete) && byComplete[next].Complete.Before(op.Invoke); next++ {
+				if prev == nil || byComplete[next].Gsn > prev.Gsn {
+					prev = &byComplete[next]
+				}
+			}
+			if prev != nil && op.Gsn <= prev.Gsn {
+				violations = append(violations, Violation{
+					Check:   CheckAppendOrder,
+					Gsn:     op.Gsn,
+					Message: fmt.Sprintf("client %d appended %q after %q, which was assigned gsn %d", client, op.Record, prev.Record, prev.Gsn),
+				})
+			}
+		}
+	}
+	return violations
+}
+
+// checkGapFree verifies that each subscription delivers consecutive global
+// sequence numbers starting from the one subscribed to, unless the records
+// skipped at the start were trimmed.
+func checkGapFree(history []Operation) []Violation {
+	var violations []Violation
+	starts := make(map[int]int32)
+	delivered := make(map[int]int32)
+	for _, op := range history {
+		switch {
+		case op.Op == OpSubscribe && op.Result == ResultOK:
+			starts[op.Subscription] = op.Gsn
+		case op.Op == OpDeliver:
+			prev, in := delivered[op.Subscription]
+			if !in {
+				start, in := starts[op.Subscription]
+				if in && op.Gsn != start && !(op.Gsn > start && trimmedBefore(history, op.Gsn, op.Complete)) {
+					violations = append(violations, Violation{
+						Check:   CheckGapFree,
+						Gsn:     op.Gsn,
+						Message: fmt.Sprintf("subscription %d from gsn %d started at gsn %d", op.Subscription, start, op.Gsn),
+					})
+				}
+			} else if op.Gsn != prev+1 {
+				violations = append(violations, Violation{
+					Check:   CheckGapFree,
+					Gsn:     op.Gsn,
+					Message: fmt.Sprintf("subscription %d delivered gsn %d after gsn %d", op.Subscription, op.Gsn, prev),
+				})
+			}
+			delivered[op.Subscription] = op.Gsn
+		}
+	}
+	return violations
+}
+
+// checkTrim verifies that reads report a record trimmed only if a trim covering
+// it was invoked before the read completed.
+func checkTrim(history []Operation) []Violation {
+	var violations []Violation
+	for _, op := range history {
+		if op.Op != OpRead || op.Result != ResultTrimmed {
+			continue
+		}
+		if !trimmedBefore(history, op.Gsn+1, op.Complete) {
+			violations = append(violations, Violation{
+				Check:   CheckTrim,
+				Gsn:     op.Gsn,
+				Message: fmt.Sprintf("read by client %d reported the record trimmed, but no trim covered it", op.Client),
+			})
+		}
+	}
+	return violations
+}
+
+// trimmedBefore returns whether a trim of the records before at least a global
+// sequence number was invoked before a time.
+func trimmedBefore(history []Operation, gsn int32, before time.Time) bool {
+	for _, op := range history {
+		if op.Op == OpTrim && op.Result == ResultOK && op.Gsn >= gsn && !op.Invoke.After(before) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package checker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	clientlib "github.com/scalog/scalog-client/lib"
+	"github.com/scalog/scalog-client/scalogtest"
+)
+
+// at returns the time a number of milliseconds after a fixed epoch.
+func at(ms int) time.Time {
+	return time.Unix(0, 0).Add(time.Duration(ms) * time.Millisecond)
+}
+
+func TestCheckViolations(t *testing.T) {
+	tests := []struct {
+		name    string
+		history []Operation
+		check   string
+	}{
+		{
+			name: "duplicate gsn",
+			history: []Operation{
+				{Client: 0, Op: OpAppend, Invoke: at(0), Complete: at(1), Gsn: 1, Record: "a", Result: ResultOK},
+				{Client: 1, Op: OpAppend, Invoke: at(0), Complete: at(1), Gsn: 1, Record: "b", Result: ResultOK},
+			},
+			check: CheckUniqueGsn,
+		},
+		{
+			name: "read disagrees with append",
+			history: []Operation{
+				{Client: 0, Op: OpAppend, Invoke: at(0), Complete: at(1), Gsn: 1, Record: "a", Result: ResultOK},
+				{Client: 1, Op: OpRead, Invoke: at(2), Complete: at(3), Gsn: 1, Record: "b", Result: ResultOK},
+			},
+			check: CheckAgreement,
+		},
+		{
+			name: "appends reordered",
+			history: []Operation{
+				{Client: 0, Op: OpAppend, Invoke: at(0), Complete: at(1), Gsn: 2, Record: "a", Result: ResultOK},
+				{Client: 0, Op: OpAppend, Invoke: at(2), Complete: at(3), Gsn: 1, Record: "b", Result: ResultOK},
+			},
+			check: CheckAppendOrder,
+		},
+		{
+			name: "delivery gap",
+			history: []Operation{
+				{Client: 0, Op: OpSubscribe, Subscription: 1, Invoke: at(0), Complete: at(0), Gsn: 1, Result: ResultOK},
+				{Client: 0, Op: OpDeliver, Subscription: 1, Invoke: at(1), Complete: at(1), Gsn: 1, Record: "a", Result: ResultOK},
+				{Client: 0, Op: OpDeliver, Subscription: 1, Invoke: at(2), Complete: at(2), Gsn: 3, Record: "c", Result: ResultOK},
+			},
+			check: CheckGapFree,
+		},
+		{
+			name: "trimmed without trim",
+			history: []Operation{
+				{Client: 0, Op: OpTrim, Invoke: at(0), Complete: at(1), Gsn: 2, Result: ResultOK},
+				{Client: 1, Op: OpRead, Invoke: at(2), Complete: at(3), Gsn: 2, Result: ResultTrimmed},
+			},
+			check: CheckTrim,
+		},
+	}
+	for _, test := range tests {
+		violations := Check(test.history)
+		if len(violations) != 1 || violations[0].Check != test.check {
+			t.Fatalf("%s: Expected: %s, Actual: %v", test.name, test.check, violations)
+		}
+	}
+}
+
+func TestCheckConsistent(t *testing.T) {
+	history := []Operation{
+		{Client: 0, Op: OpAppend, Invoke: at(0), Complete: at(2), Gsn: 2, Record: "b", Result: ResultOK},
+		{Client: 0, Op: OpAppend, Invoke: at(1), Complete: at(3), Gsn: 1, Record: "a", Result: ResultOK},
+		{Client: 1, Op: OpSubscribe, Subscription: 1, Invoke: at(4), Complete: at(4), Gsn: 1, Result: ResultOK},
+		{Client: 0, Op: OpTrim, Invoke: at(5), Complete: at(5), Gsn: 2, Result: ResultOK},
+		{Client: 1, Op: OpRead, Invoke: at(6), Complete: at(7), Gsn: 1, Result: ResultTrimmed},
+		{Client: 1, Op: OpDeliver, Subscription: 1, Invoke: at(8), Complete: at(8), Gsn: 2, Record: "b", Result: ResultOK},
+		{Client: 1, Op: OpDeliver, Subscription: 1, Invoke: at(9), Complete: at(9), Gsn: 3, Record: "c", Result: ResultOK},
+	}
+	if violations := Check(history); len(violations) != 0 {
+		t.Fatalf("Expected: %v, Actual: %v", nil, violations)
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	cluster, err := scalogtest.NewCluster(2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cluster.Close()
+	client, err := clientlib.NewClient(clientlib.WithDiscoveryAddress(cluster.DiscoveryAddress()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	recorder := NewRecorder()
+	recorded := recorder.Client(7, client)
+	gsn, _, err := recorded.Append("Hello, World!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := recorded.Read(gsn + 1); err == nil {
+		t.Fatalf("Expected error reading uncommitted record")
+	}
+	dir, err := ioutil.TempDir("", "checker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "history.json")
+	err = recorder.WriteFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	history, err := ReadHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("Expected: %d, Actual: %d", 2, len(history))
+	}
+	if history[0].Client != 7 || history[0].Op != OpAppend || history[0].Gsn != gsn || history[0].Result != ResultOK {
+		t.Fatalf("Unexpected append %+v", history[0])
+	}
+	if history[1].Op != OpRead || history[1].Result != ResultNotCommitted {
+		t.Fatalf("Expected: %s, Actual: %s", ResultNotCommitted, history[1].Result)
+	}
+}
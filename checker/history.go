@@ -0,0 +1,241 @@
+// Package checker records the operations clients perform on Scalog to a
+// history, and verifies offline that the history is consistent with a totally
+// ordered shared log.
+package checker
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	clientlib "github.com/scalog/scalog-client/lib"
+)
+
+// Kinds of operations in a history.
+const (
+	// OpAppend appends a record and returns its global sequence number.
+	OpAppend = "append"
+	// OpRead reads the record with a global sequence number.
+	OpRead = "read"
+	// OpTrim trims the records before a global sequence number.
+	OpTrim = "trim"
+	// OpSubscribe subscribes to records starting from a global sequence
+	// number.
+	OpSubscribe = "subscribe"
+	// OpDeliver is the delivery of a record to a subscription.
+	OpDeliver = "deliver"
+)
+
+// Results of operations in a history.
+const (
+	// ResultOK indicates that the operation succeeded.
+	ResultOK = "ok"
+	// ResultTrimmed indicates that the record had been trimmed.
+	ResultTrimmed = "trimmed"
+	// ResultNotCommitted indicates that the record had not been committed.
+	ResultNotCommitted = "not-committed"
+	// ResultFailed indicates that the operation failed for another reason.
+	ResultFailed = "failed"
+)
+
+// Operation is an operation performed by a client.
+type Operation struct {
+	// Identifier of the client that performed the operation
+	Client int `json:"client"`
+	// Kind of operation, one of the Op constants
+	Op string `json:"op"`
+	// Identifier of the subscription of OpSubscribe and OpDeliver operations
+	Subscription int `json:"subscription,omitempty"`
+	// Times at which the operation was invoked and completed
+	Invoke   time.Time `json:"invoke"`
+	Complete time.Time `json:"complete"`
+	// Global sequence number the operation returned or targeted
+	Gsn int32 `json:"gsn"`
+	// Identifier of the shard the operation targeted, or -1 if none
+	ShardID int32 `json:"shard"`
+	// Data of the record appended, read or delivered
+	Record string `json:"record,omitempty"`
+	// Result of the operation, one of the Result constants
+	Result string `json:"result"`
+	// Error returned by the operation, if any
+	Error string `json:"error,omitempty"`
+}
+
+// Recorder records the operations of concurrent clients to a history.
+type Recorder struct {
+	// Operations in order of completion
+	history []Operation
+	// Identifier to be assigned to the next subscription
+	nextSubscription int
+	// Mutex for accessing the history
+	mu sync.Mutex
+}
+
+// Client is a Scalog client whose operations are recorded by a Recorder.
+type Client struct {
+	// Identifier of the client in the history
+	id int
+	// Client performing the operations
+	client *clientlib.Client
+	// Recorder of the operations
+	recorder *Recorder
+}
+
+// NewRecorder returns a new instance of Recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{nextSubscription: 1}
+}
+
+// Client returns a Client that records the operations of a Scalog client under
+// an identifier.
+func (r *Recorder) Client(id int, client *clientlib.Client) *Client {
+	return &Client{id: id, client: client, recorder: r}
+}
+
+// History returns the recorded operations in order of completion.
+func (r *Recorder) History() []Operation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	history := make([]Operation, len(r.history))
+	copy(history, r.history)
+	return history
+}
+
+// WriteFile writes the recorded operations to a JSON file.
+func (r *Recorder) WriteFile(path string) error {
+	b, err := json.MarshalIndent(r.History(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+// ReadHistory reads a history from a JSON file written by Recorder.WriteFile.
+func ReadHistory(path string) ([]Operation, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var history []Operation
+	err = json.Unmarshal(b, &history)
+	if err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// record adds an operation to the history.
+func (r *Recorder) record(op Operation) {
+	r.mu.Lock()
+	r.history = append(r.history, op)
+	r.mu.Unlock()
+}
+
+// subscription returns the identifier of a new subscription.
+func (r *Recorder) subscription() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id := r.nextSubscription
+	r.nextSubscription++
+	return id
+}
+
+// Append appends a record to Scalog and returns its global sequence number and
+// the shard it was appended to.
+func (c *Client) Append(record string) (int32, int32, error) {
+	invoke := time.Now()
+	gsn, shardID, err := c.client.AppendToShard(record)
+	c.recorder.record(c.operation(OpAppend, invoke, gsn, shardID, record, err))
+	return gsn, shardID, err
+}
+
+// Read reads the record with a global sequence number from any shard.
+func (c *Client) Read(gsn int32) (string, error) {
+	invoke := time.Now()
+	record, err := c.client.Read(gsn)
+	c.recorder.record(c.operation(OpRead, invoke, gsn, -1, record, err))
+	return record, err
+}
+
+// ReadRecord reads the record with a global sequence number from a shard.
+func (c *Client) ReadRecord(gsn int32, shardID int32) (string, error) {
+	invoke := time.Now()
+	record, err := c.client.ReadRecord(gsn, shardID)
+	c.recorder.record(c.operation(OpRead, invoke, gsn, shardID, record, err))
+	return record, err
+}
+
+// Trim trims the records before a global sequence number.
+func (c *Client) Trim(gsn int32) error {
+	invoke := time.Now()
+	err := c.client.Trim(gsn)
+	c.recorder.record(c.operation(OpTrim, invoke, gsn, -1, "", err))
+	return err
+}
+
+// Subscribe subscribes to records starting from a global sequence number. Every
+// record received on the returned channel is recorded as delivered.
+func (c *Client) Subscribe(gsn int32) (chan clientlib.CommittedRecord, error) {
+	invoke := time.Now()
+	subscribeChan, err := c.client.Subscribe(gsn)
+	op := c.operation(OpSubscribe, invoke, gsn, -1, "", err)
+	if err != nil {
+		c.recorder.record(op)
+		return nil, err
+	}
+	op.Subscription = c.recorder.subscription()
+	c.recorder.record(op)
+	deliverChan := make(chan clientlib.CommittedRecord)
+	go func() {
+		for committedRecord := range subscribeChan {
+			now := time.Now()
+			c.recorder.record(Operation{
+				Client:       c.id,
+				Op:           OpDeliver,
+				Subscription: op.Subscription,
+				Invoke:       now,
+				Complete:     now,
+				Gsn:          committedRecord.Gsn,
+				ShardID:      -1,
+				Record:       committedRecord.Record,
+				Result:       ResultOK,
+			})
+			deliverChan <- committedRecord
+		}
+		close(deliverChan)
+	}()
+	return deliverChan, nil
+}
+
+// operation returns the completed operation of the client.
+func (c *Client) operation(kind string, invoke time.Time, gsn int32, shardID int32, record string, err error) Operation {
+	op := Operation{
+		Client:   c.id,
+		Op:       kind,
+		Invoke:   invoke,
+		Complete: time.Now(),
+		Gsn:      gsn,
+		ShardID:  shardID,
+		Record:   record,
+		Result:   resultOf(err),
+	}
+	if err != nil {
+		op.Error = err.Error()
+	}
+	return op
+}
+
+// resultOf returns the result of an operation that returned err.
+func resultOf(err error) string {
+	switch {
+	case err == nil:
+		return ResultOK
+	case errors.Is(err, clientlib.ErrRecordTrimmed):
+		return ResultTrimmed
+	case errors.Is(err, clientlib.ErrNotCommitted):
+		return ResultNotCommitted
+	}
+	return ResultFailed
+}
@@ -16,8 +16,10 @@ package cmd
 
 import (
 	"github.com/scalog/scalog-client/bench"
+	clientlib "github.com/scalog/scalog-client/lib"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 // benchCmd represents the bench command
@@ -34,7 +36,7 @@ var benchCmd = &cobra.Command{
 		if err != nil {
 			panic(err)
 		}
-		b, err := bench.NewBench(num, size)
+		b, err := bench.NewBench(num, size, clientlib.WithViper(viper.GetViper()))
 		if err != nil {
 			panic(err)
 		}
@@ -0,0 +1,134 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+
+	clientlib "github.com/scalog/scalog-client/lib"
+)
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect and modify the configuration",
+	Long:  `Inspect and modify the configuration`,
+}
+
+// configViewCmd represents the config view command
+var configViewCmd = &cobra.Command{
+	Use:   "view",
+	Short: "Show the resolved configuration",
+	Long:  `Show the configuration resolved from flags, environment variables and the config file`,
+	Run: func(cmd *cobra.Command, args []string) {
+		b, err := clientlib.MarshalConfig(viper.GetViper())
+		if err != nil {
+			panic(err)
+		}
+		if viper.ConfigFileUsed() != "" {
+			fmt.Printf("# config: %s\n", viper.ConfigFileUsed())
+		}
+		if context := clientlib.CurrentContext(viper.GetViper()); context != "" {
+			fmt.Printf("# context: %s\n", context)
+		}
+		fmt.Print(string(b))
+	},
+}
+
+// configGetContextsCmd represents the config get-contexts command
+var configGetContextsCmd = &cobra.Command{
+	Use:   "get-contexts",
+	Short: "List the contexts of the config file",
+	Long:  `List the contexts of the config file, marking the selected one with *`,
+	Run: func(cmd *cobra.Command, args []string) {
+		current := clientlib.CurrentContext(viper.GetViper())
+		var names []string
+		for name := range viper.GetStringMap("contexts") {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			marker := " "
+			if name == current {
+				marker = "*"
+			}
+			fmt.Printf("%s %s\n", marker, name)
+		}
+	},
+}
+
+// configUseContextCmd represents the config use-context command
+var configUseContextCmd = &cobra.Command{
+	Use:   "use-context [name]",
+	Short: "Set the current context of the config file",
+	Long:  `Set the context used by default by writing current-context to the config file, leaving its other lines unchanged`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := useContext(viper.ConfigFileUsed(), args[0])
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("Switched to context %s\n", args[0])
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configViewCmd)
+	configCmd.AddCommand(configGetContextsCmd)
+	configCmd.AddCommand(configUseContextCmd)
+}
+
+// useContext sets current-context in the config file at path to the name of
+// one of its contexts. Only the top-level current-context line is rewritten, or
+// added at the top of the file, so that comments and formatting are preserved.
+func useContext(path string, name string) error {
+	if path == "" {
+		return fmt.Errorf("No config file found")
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	// Viper lowercases the keys of maps, so context names are case-insensitive
+	if _, in := viper.GetStringMap("contexts")[strings.ToLower(name)]; !in {
+		return fmt.Errorf("Context %s not found in %s", name, path)
+	}
+	value, err := yaml.Marshal(name)
+	if err != nil {
+		return err
+	}
+	line := "current-context: " + string(value)
+	lines := strings.SplitAfter(string(b), "\n")
+	found := false
+	for i := range lines {
+		if strings.HasPrefix(lines[i], "current-context:") {
+			lines[i] = line
+			found = true
+			break
+		}
+	}
+	if !found {
+		lines = append([]string{line}, lines...)
+	}
+	return ioutil.WriteFile(path, []byte(strings.Join(lines, "")), 0644)
+}
@@ -16,8 +16,10 @@ package cmd
 
 import (
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 
 	"github.com/scalog/scalog-client/it"
+	clientlib "github.com/scalog/scalog-client/lib"
 )
 
 // itCmd represents the it command
@@ -26,7 +28,7 @@ var itCmd = &cobra.Command{
 	Short: "Scalog interactive client",
 	Long:  `Scalog interactive client`,
 	Run: func(cmd *cobra.Command, args []string) {
-		i, err := it.NewIt()
+		i, err := it.NewIt(clientlib.WithViper(viper.GetViper()))
 		if err != nil {
 			panic(err)
 		}
@@ -17,10 +17,13 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+
+	clientlib "github.com/scalog/scalog-client/lib"
 )
 
 var cfgFile string
@@ -55,35 +58,39 @@ func init() {
 	// Here you will define your flags and configuration settings.
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
-	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.scalog-client.yaml)")
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is ./config.yaml, or else $HOME/.scalog-client.yaml)")
+	rootCmd.PersistentFlags().String("context", "", "context of the config file to use (default is current-context)")
+	rootCmd.PersistentFlags().String("discovery-ip", "", "IP of the Scalog discovery service")
+	rootCmd.PersistentFlags().Int32("discovery-port", 0, "port of the Scalog discovery service")
+	viper.BindPFlag("context", rootCmd.PersistentFlags().Lookup("context"))
+	viper.BindPFlag("discovery-address.ip", rootCmd.PersistentFlags().Lookup("discovery-ip"))
+	viper.BindPFlag("discovery-address.port", rootCmd.PersistentFlags().Lookup("discovery-port"))
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
 	rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 }
 
-// initConfig reads in config file and ENV variables if set.
+// initConfig reads in config file, ENV variables and flags, in the same way as
+// the client library.
 func initConfig() {
-	if cfgFile != "" {
-		// Use config file from the flag.
-		viper.SetConfigFile(cfgFile)
-	} else {
-		// Find home directory.
-		home, err := homedir.Dir()
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+	if cfgFile == "" {
+		// Fall back to the config file in the home directory if there is none in
+		// the working directory.
+		if _, err := os.Stat("config.yaml"); os.IsNotExist(err) {
+			home, err := homedir.Dir()
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			if _, err := os.Stat(filepath.Join(home, ".scalog-client.yaml")); err == nil {
+				cfgFile = filepath.Join(home, ".scalog-client.yaml")
+			}
 		}
-
-		// Search config in home directory with name ".scalog-client" (without extension).
-		viper.AddConfigPath(home)
-		viper.SetConfigName(".scalog-client")
 	}
 
-	viper.AutomaticEnv() // read in environment variables that match
-
-	// If a config file is found, read it in.
-	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+	if err := clientlib.ConfigureViper(viper.GetViper(), cfgFile); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
 }
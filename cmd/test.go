@@ -16,21 +16,53 @@ package cmd
 
 import (
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 
+	clientlib "github.com/scalog/scalog-client/lib"
+	"github.com/scalog/scalog-client/scalogtest"
 	"github.com/scalog/scalog-client/test"
 )
 
+// Whether to run the test against an in-process cluster, and the shape of that
+// cluster
+var (
+	testLocal    bool
+	testShards   int
+	testReplicas int
+)
+
+// Number of concurrent clients and appends per client of the concurrent test,
+// and the file its history is written to
+var (
+	testClients int
+	testNum     int
+	testHistory string
+)
+
 // testCmd represents the test command
 var testCmd = &cobra.Command{
 	Use:   "test",
 	Short: "Integrated test",
 	Long:  `Integrated test`,
 	Run: func(cmd *cobra.Command, args []string) {
-		t, err := test.NewTest()
+		opts := []clientlib.Option{clientlib.WithViper(viper.GetViper())}
+		if testLocal {
+			cluster, err := scalogtest.NewCluster(testShards, testReplicas)
+			if err != nil {
+				panic(err)
+			}
+			defer cluster.Close()
+			opts = append(opts, clientlib.WithDiscoveryAddress(cluster.DiscoveryAddress()))
+		}
+		t, err := test.NewTest(opts...)
 		if err != nil {
 			panic(err)
 		}
-		err = t.Start()
+		if testClients > 0 {
+			err = t.StartConcurrent(testClients, testNum, testHistory)
+		} else {
+			err = t.Start()
+		}
 		if err != nil {
 			panic(err)
 		}
@@ -49,4 +81,10 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// testCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	testCmd.Flags().BoolVar(&testLocal, "local", false, "Run against an in-process cluster instead of config.yaml")
+	testCmd.Flags().IntVar(&testShards, "shards", 2, "Number of shards of the in-process cluster")
+	testCmd.Flags().IntVar(&testReplicas, "replicas", 2, "Number of replicas per shard of the in-process cluster")
+	testCmd.Flags().IntVar(&testClients, "clients", 0, "Number of concurrent clients whose operations are recorded and checked")
+	testCmd.Flags().IntVar(&testNum, "num", 32, "Number of appends per concurrent client")
+	testCmd.Flags().StringVar(&testHistory, "history", "", "File to which the history of the concurrent test is written")
 }
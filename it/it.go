@@ -3,6 +3,7 @@ package it
 import (
 	"bufio"
 	"fmt"
+	"log"
 	"os"
 	"regexp"
 	"strconv"
@@ -13,15 +14,18 @@ import (
 
 type It struct {
 	client *clientlib.Client
+	logger clientlib.Logger
 }
 
-func NewIt() (*It, error) {
-	client, err := clientlib.NewClient()
+func NewIt(opts ...clientlib.Option) (*It, error) {
+	logger := clientlib.NewStdLogger(log.New(os.Stderr, "", log.LstdFlags), clientlib.LevelWarn)
+	client, err := clientlib.NewClient(append(opts, clientlib.WithLogger(logger))...)
 	if err != nil {
 		return nil, err
 	}
 	it := &It{}
 	it.client = client
+	it.logger = logger
 	return it, nil
 }
 
@@ -31,7 +35,7 @@ func (it *It) Start() error {
 	for {
 		cmdString, err := reader.ReadString('\n')
 		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
+			it.logger.Error("Failed to read command", "err", err)
 			continue
 		}
 		cmdString = strings.TrimSuffix(cmdString, "\n")
@@ -51,7 +55,7 @@ func (it *It) Start() error {
 			record := strings.Join(cmd[1:], " ")
 			gsn, err := it.client.Append(record)
 			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
+				it.logger.Error("Command failed", "command", cmd[0], "err", err)
 				continue
 			}
 			fmt.Fprintf(os.Stderr, "Append result: { Gsn: %d, Record: %s }\n", gsn, record)
@@ -63,7 +67,7 @@ func (it *It) Start() error {
 			record := strings.Join(cmd[1:], " ")
 			gsn, shardID, err := it.client.AppendToShard(record)
 			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
+				it.logger.Error("Command failed", "command", cmd[0], "err", err)
 				continue
 			}
 			fmt.Fprintf(os.Stderr, "AppendToShard result: { Gsn: %d, Record: %s }, shardID: %d\n", gsn, record, shardID)
@@ -74,7 +78,7 @@ func (it *It) Start() error {
 			}
 			gsn, err := strconv.ParseInt(cmd[1], 10, 32)
 			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
+				it.logger.Error("Command failed", "command", cmd[0], "err", err)
 				continue
 			}
 			if gsn < 1 {
@@ -83,7 +87,7 @@ func (it *It) Start() error {
 			}
 			subscribeChan, err := it.client.Subscribe(int32(gsn))
 			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
+				it.logger.Error("Command failed", "command", cmd[0], "err", err)
 				continue
 			}
 			go func() {
@@ -98,7 +102,7 @@ func (it *It) Start() error {
 			}
 			gsn, err := strconv.ParseInt(cmd[1], 10, 32)
 			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
+				it.logger.Error("Command failed", "command", cmd[0], "err", err)
 				continue
 			}
 			if gsn < 1 {
@@ -107,7 +111,7 @@ func (it *It) Start() error {
 			}
 			shardID, err := strconv.ParseInt(cmd[2], 10, 32)
 			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
+				it.logger.Error("Command failed", "command", cmd[0], "err", err)
 				continue
 			}
 			if shardID < 0 {
@@ -116,10 +120,63 @@ func (it *It) Start() error {
 			}
 			record, err := it.client.ReadRecord(int32(gsn), int32(shardID))
 			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
+				it.logger.Error("Command failed", "command", cmd[0], "err", err)
 				continue
 			}
 			fmt.Fprintf(os.Stderr, "ReadRecord result: { Record: %s }\n", record)
+		} else if cmd[0] == "read" {
+			if len(cmd) < 2 {
+				fmt.Fprintln(os.Stderr, "Command error: missing required argument [gsn]")
+				continue
+			}
+			gsn, err := strconv.ParseInt(cmd[1], 10, 32)
+			if err != nil {
+				it.logger.Error("Command failed", "command", cmd[0], "err", err)
+				continue
+			}
+			if gsn < 1 {
+				fmt.Fprintln(os.Stderr, "Command error: [gsn] must be greater than 0")
+				continue
+			}
+			record, err := it.client.Read(int32(gsn))
+			if err != nil {
+				it.logger.Error("Command failed", "command", cmd[0], "err", err)
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "Read result: { Record: %s }\n", record)
+		} else if strings.EqualFold(cmd[0], "readRange") {
+			if len(cmd) < 3 {
+				fmt.Fprintln(os.Stderr, "Command error: missing required arguments [from] [to]")
+				continue
+			}
+			from, err := strconv.ParseInt(cmd[1], 10, 32)
+			if err != nil {
+				it.logger.Error("Command failed", "command", cmd[0], "err", err)
+				continue
+			}
+			if from < 1 {
+				fmt.Fprintln(os.Stderr, "Command error: [from] must be greater than 0")
+				continue
+			}
+			to, err := strconv.ParseInt(cmd[2], 10, 32)
+			if err != nil {
+				it.logger.Error("Command failed", "command", cmd[0], "err", err)
+				continue
+			}
+			if to < from {
+				fmt.Fprintln(os.Stderr, "Command error: [to] must be greater than or equal to [from]")
+				continue
+			}
+			recordIterator := it.client.ReadRange(int32(from), int32(to))
+			for recordIterator.Next() {
+				committedRecord := recordIterator.Record()
+				fmt.Fprintf(os.Stderr, "ReadRange result: { Gsn: %d, Record: %s }\n", committedRecord.Gsn, committedRecord.Record)
+			}
+			recordIterator.Close()
+			if recordIterator.Err() != nil {
+				it.logger.Error("Command failed", "command", cmd[0], "err", recordIterator.Err())
+				continue
+			}
 		} else if cmd[0] == "trim" {
 			if len(cmd) < 2 {
 				fmt.Fprintln(os.Stderr, "Command error: missing required argument [gsn]")
@@ -127,7 +184,7 @@ func (it *It) Start() error {
 			}
 			gsn, err := strconv.ParseInt(cmd[1], 10, 32)
 			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
+				it.logger.Error("Command failed", "command", cmd[0], "err", err)
 				continue
 			}
 			if gsn < 1 {
@@ -136,7 +193,7 @@ func (it *It) Start() error {
 			}
 			err = it.client.Trim(int32(gsn))
 			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
+				it.logger.Error("Command failed", "command", cmd[0], "err", err)
 				continue
 			}
 			fmt.Fprintln(os.Stderr, "Trim result: {}")
@@ -145,7 +202,9 @@ func (it *It) Start() error {
 			fmt.Fprintln(os.Stderr, "    append [record]")
 			fmt.Fprintln(os.Stderr, "    appendToShard [record]")
 			fmt.Fprintln(os.Stderr, "    subscribe [gsn]")
+			fmt.Fprintln(os.Stderr, "    read [gsn]")
 			fmt.Fprintln(os.Stderr, "    readRecord [gsn] [shardID]")
+			fmt.Fprintln(os.Stderr, "    readRange [from] [to]")
 			fmt.Fprintln(os.Stderr, "    trim [gsn]")
 			fmt.Fprintln(os.Stderr, "    exit")
 		} else {
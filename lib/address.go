@@ -0,0 +1,154 @@
+package lib
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	discovery "github.com/scalog/scalog/discovery/rpc"
+)
+
+// AddressRule rewrites the addresses of data servers advertised by the
+// discovery service, such as cluster-internal IPs that are unreachable from the
+// client.
+//
+// From matches the advertised address and is one of:
+//
+//	10.0.0.5:26000   the IP and port
+//	10.0.0.5         the IP with any port
+//	10.0.0.0/8       any IP in the CIDR block with any port
+//
+// To replaces the matched address and is either host:port, which replaces both
+// the IP and port, or host, which replaces the IP only and keeps the advertised
+// port.
+type AddressRule struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+}
+
+// addressMapper translates the addresses of data servers with an ordered list
+// of rules, the first matching rule applying.
+type addressMapper struct {
+	rules []compiledRule
+}
+
+// compiledRule is the parsed form of an AddressRule.
+type compiledRule struct {
+	// CIDR block matched, or nil if the rule matches a single IP
+	network *net.IPNet
+	// IP matched if network is nil
+	ip net.IP
+	// Port matched, or 0 to match any port
+	port int32
+	// Host substituted for the matched IP
+	host string
+	// Port substituted for the matched port, or 0 to keep the advertised port
+	toPort int32
+}
+
+// WithAddressRules sets the rules translating the addresses of data servers
+// advertised by the discovery service, overriding address-rules in
+// config.yaml.
+func WithAddressRules(rules ...AddressRule) Option {
+	return func(c *Client) error {
+		c.config.AddressRules = rules
+		return nil
+	}
+}
+
+// newAddressMapper returns an addressMapper applying rules in order.
+func newAddressMapper(rules []AddressRule) (*addressMapper, error) {
+	m := &addressMapper{rules: make([]compiledRule, 0, len(rules))}
+	for _, rule := range rules {
+		compiled, err := compileRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		m.rules = append(m.rules, compiled)
+	}
+	return m, nil
+}
+
+// compileRule parses an AddressRule.
+func compileRule(rule AddressRule) (compiledRule, error) {
+	var compiled compiledRule
+	if _, network, err := net.ParseCIDR(rule.From); err == nil {
+		compiled.network = network
+	} else {
+		host, port, err := splitAddress(rule.From)
+		if err != nil {
+			return compiled, fmt.Errorf("Invalid address rule from %q: %v", rule.From, err)
+		}
+		compiled.ip = net.ParseIP(host)
+		if compiled.ip == nil {
+			return compiled, fmt.Errorf("Invalid address rule from %q: %s is not an IP", rule.From, host)
+		}
+		compiled.port = port
+	}
+	host, port, err := splitAddress(rule.To)
+	if err != nil || host == "" {
+		return compiled, fmt.Errorf("Invalid address rule to %q", rule.To)
+	}
+	compiled.host, compiled.toPort = host, port
+	return compiled, nil
+}
+
+// splitAddress splits host or host:port into a host and port, the port being 0
+// if absent.
+func splitAddress(s string) (string, int32, error) {
+	host, portString, err := net.SplitHostPort(s)
+	if err != nil {
+		// Not host:port, so s is a host alone, possibly an IPv6 address
+		return s, 0, nil
+	}
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil || port == 0 {
+		return "", 0, fmt.Errorf("Invalid port %s", portString)
+	}
+	return host, int32(port), nil
+}
+
+// matches returns whether a rule matches an advertised address.
+func (r *compiledRule) matches(ip net.IP, port int32) bool {
+	if r.port != 0 && r.port != port {
+		return false
+	}
+	if r.network != nil {
+		return r.network.Contains(ip)
+	}
+	return r.ip.Equal(ip)
+}
+
+// translate returns the address a data server advertising ip and port is
+// reached at.
+func (m *addressMapper) translate(ip string, port int32) (string, int32) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip, port
+	}
+	for _, rule := range m.rules {
+		if !rule.matches(parsed, port) {
+			continue
+		}
+		if rule.toPort != 0 {
+			return rule.host, rule.toPort
+		}
+		return rule.host, port
+	}
+	return ip, port
+}
+
+// translateView returns a copy of a view with the addresses of its data
+// servers translated, leaving the given view unmodified.
+func (m *addressMapper) translateView(view []*discovery.Shard) []*discovery.Shard {
+	translated := make([]*discovery.Shard, len(view))
+	for i, shard := range view {
+		servers := make([]*discovery.DataServer, len(shard.Servers))
+		for j, server := range shard.Servers {
+			ip, port := m.translate(server.Ip, server.Port)
+			servers[j] = &discovery.DataServer{ServerID: server.ServerID, Ip: ip, Port: port}
+		}
+		translated[i] = &discovery.Shard{ShardID: shard.ShardID, Servers: servers}
+	}
+	return translated
+}
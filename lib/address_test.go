@@ -0,0 +1,78 @@
+package lib
+
+import (
+	"testing"
+
+	discovery "github.com/scalog/scalog/discovery/rpc"
+)
+
+func TestAddressMapper(t *testing.T) {
+	mapper, err := newAddressMapper([]AddressRule{
+		{From: "10.0.0.5:26000", To: "scalog-0.example.com:27000"},
+		{From: "10.0.0.6", To: "203.0.113.6"},
+		{From: "10.0.0.0/8", To: "127.0.0.1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		ip           string
+		port         int32
+		expectedIP   string
+		expectedPort int32
+	}{
+		{"10.0.0.5", 26000, "scalog-0.example.com", 27000},
+		{"10.0.0.5", 26001, "127.0.0.1", 26001},
+		{"10.0.0.6", 26002, "203.0.113.6", 26002},
+		{"10.1.2.3", 26003, "127.0.0.1", 26003},
+		{"192.168.0.1", 26004, "192.168.0.1", 26004},
+	}
+	for _, test := range tests {
+		ip, port := mapper.translate(test.ip, test.port)
+		if ip != test.expectedIP || port != test.expectedPort {
+			t.Fatalf("Expected: %s:%d, Actual: %s:%d", test.expectedIP, test.expectedPort, ip, port)
+		}
+	}
+}
+
+func TestAddressMapperView(t *testing.T) {
+	mapper, err := newAddressMapper([]AddressRule{{From: "0.0.0.0/0", To: "127.0.0.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	view := []*discovery.Shard{{ShardID: 0, Servers: []*discovery.DataServer{{ServerID: 1, Ip: "10.0.0.1", Port: 26000}}}}
+	translated := mapper.translateView(view)
+	if address := getAddressOfServer(translated[0].Servers[0]); address != "127.0.0.1:26000" {
+		t.Fatalf("Expected: %s, Actual: %s", "127.0.0.1:26000", address)
+	}
+	if address := getAddressOfServer(view[0].Servers[0]); address != "10.0.0.1:26000" {
+		t.Fatalf("Expected: %s, Actual: %s", "10.0.0.1:26000", address)
+	}
+}
+
+func TestAddressRuleInvalid(t *testing.T) {
+	for _, rule := range []AddressRule{
+		{From: "scalog.example.com", To: "127.0.0.1"},
+		{From: "10.0.0.1", To: ""},
+		{From: "10.0.0.1:http", To: "127.0.0.1"},
+	} {
+		if _, err := newAddressMapper([]AddressRule{rule}); err == nil {
+			t.Fatalf("Expected error for rule %v", rule)
+		}
+	}
+}
+
+func TestAddressOfServer(t *testing.T) {
+	tests := []struct {
+		server   *discovery.DataServer
+		expected string
+	}{
+		{&discovery.DataServer{Ip: "10.0.0.5", Port: 26000}, "10.0.0.5:26000"},
+		{&discovery.DataServer{Ip: "fd00::5", Port: 26000}, "[fd00::5]:26000"},
+	}
+	for _, test := range tests {
+		if address := getAddressOfServer(test.server); address != test.expected {
+			t.Fatalf("Expected: %s, Actual: %s", test.expected, address)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// defaultRefreshMargin is how long before its expiry a token is refreshed.
+const defaultRefreshMargin = 30 * time.Second
+
+// TokenSource provides the bearer token attached to every request the client
+// sends to Scalog.
+type TokenSource interface {
+	// Token returns the current bearer token.
+	Token(ctx context.Context) (string, error)
+}
+
+// TokenRefresher obtains a new bearer token and the time at which it expires.
+type TokenRefresher func(ctx context.Context) (token string, expiry time.Time, err error)
+
+// AuthError indicates that Scalog rejected a request because its credentials
+// were missing or invalid, or that credentials could not be obtained.
+type AuthError struct {
+	// Full gRPC method name of the rejected request
+	Method string
+	// Error returned for the request
+	Err error
+}
+
+// staticTokenSource is a TokenSource that always returns the same token.
+type staticTokenSource string
+
+// fileTokenSource is a TokenSource that reads the token from a file, and
+// rereads it whenever the file is modified.
+type fileTokenSource struct {
+	// Path of the file holding the token
+	path string
+	// Most recently read token
+	token string
+	// Modification time of the file when the token was read
+	modTime time.Time
+	// Mutex for accessing token and modTime
+	mu sync.Mutex
+}
+
+// refreshingTokenSource is a TokenSource that caches the token obtained from a
+// TokenRefresher until shortly before it expires.
+type refreshingTokenSource struct {
+	// Function that obtains a new token
+	refresh TokenRefresher
+	// How long before its expiry the token is refreshed
+	margin time.Duration
+	// Cached token
+	token string
+	// Expiry of the cached token
+	expiry time.Time
+	// Mutex for accessing token and expiry
+	mu sync.Mutex
+}
+
+// tokenCredentials attaches the token of a TokenSource to requests as gRPC
+// metadata.
+type tokenCredentials struct {
+	source TokenSource
+	// Whether the token may be sent over connections without TLS
+	insecure bool
+}
+
+// authStream converts the errors of a client stream into AuthErrors.
+type authStream struct {
+	grpc.ClientStream
+	method string
+}
+
+// WithTokenSource attaches a bearer token from source to the metadata of every
+// request the client sends to the discovery service and data servers. Tokens
+// are only sent over TLS unless WithInsecureTokens is set.
+func WithTokenSource(source TokenSource) Option {
+	return func(c *Client) error {
+		c.tokenSource = source
+		return nil
+	}
+}
+
+// WithInsecureTokens allows bearer tokens to be sent over connections without
+// TLS, for deployments in which a gateway in front of Scalog terminates TLS.
+// Without it, a client with a TokenSource requires TLS.
+func WithInsecureTokens() Option {
+	return func(c *Client) error {
+		c.insecureTokens = true
+		return nil
+	}
+}
+
+// StaticToken returns a TokenSource that always returns token.
+func StaticToken(token string) TokenSource {
+	return staticTokenSource(token)
+}
+
+// NewFileTokenSource returns a TokenSource that reads the token from the file
+// at path, and rereads it whenever the file is modified.
+func NewFileTokenSource(path string) TokenSource {
+	return &fileTokenSource{path: path}
+}
+
+// NewRefreshingTokenSource returns a TokenSource that obtains tokens from
+// refresh and reuses each token until shortly before it expires.
+func NewRefreshingTokenSource(refresh TokenRefresher) TokenSource {
+	return &refreshingTokenSource{
+		refresh: refresh,
+		margin:  defaultRefreshMargin,
+	}
+}
+
+// Error returns a description of the authentication failure.
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("Authentication failed for %s: %v", e.Method, e.Err)
+}
+
+// Unwrap returns the error returned for the request.
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+// GRPCStatus returns the gRPC status of the error returned for the request.
+func (e *AuthError) GRPCStatus() *status.Status {
+	return status.Convert(e.Err)
+}
+
+// Token returns the static token.
+func (s staticTokenSource) Token(ctx context.Context) (string, error) {
+	return string(s), nil
+}
+
+// Token returns the token in the file, rereading the file if it was modified.
+func (s *fileTokenSource) Token(ctx context.Context) (string, error) {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && info.ModTime().Equal(s.modTime) {
+		return s.token, nil
+	}
+	file, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return "", err
+	}
+	s.token = strings.TrimSpace(string(file))
+	s.modTime = info.ModTime()
+	return s.token, nil
+}
+
+// Token returns the cached token, refreshing it if it is about to expire.
+func (s *refreshingTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && time.Now().Add(s.margin).Before(s.expiry) {
+		return s.token, nil
+	}
+	token, expiry, err := s.refresh(ctx)
+	if err != nil {
+		return "", err
+	}
+	s.token = token
+	s.expiry = expiry
+	return s.token, nil
+}
+
+// GetRequestMetadata returns the authorization metadata of a request.
+func (tc tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	token, err := tc.source.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"authorization": "Bearer " + token}, nil
+}
+
+// RequireTransportSecurity returns whether the token may only be sent over TLS.
+func (tc tokenCredentials) RequireTransportSecurity() bool {
+	return !tc.insecure
+}
+
+// RecvMsg receives a message from the stream.
+func (s *authStream) RecvMsg(m interface{}) error {
+	return toAuthError(s.method, s.ClientStream.RecvMsg(m))
+}
+
+// authUnaryInterceptor converts authentication failures of unary requests
+// into AuthErrors.
+func authUnaryInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	return toAuthError(method, invoker(ctx, method, req, reply, cc, opts...))
+}
+
+// authStreamInterceptor converts authentication failures of streaming
+// requests into AuthErrors.
+func authStreamInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	stream, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		return nil, toAuthError(method, err)
+	}
+	return &authStream{ClientStream: stream, method: method}, nil
+}
+
+// toAuthError returns an AuthError wrapping err if err indicates an
+// authentication failure, and err otherwise.
+func toAuthError(method string, err error) error {
+	if status.Code(err) != codes.Unauthenticated {
+		return err
+	}
+	return &AuthError{Method: method, Err: err}
+}
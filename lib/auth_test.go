@@ -0,0 +1,168 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	discovery "github.com/scalog/scalog/discovery/rpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// authDiscoveryServer is a discovery service that requires a bearer token.
+type authDiscoveryServer struct {
+	staticDiscoveryServer
+	token string
+}
+
+func (s authDiscoveryServer) DiscoverServers(ctx context.Context, req *discovery.DiscoverRequest) (*discovery.DiscoverResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if len(md["authorization"]) != 1 || md["authorization"][0] != "Bearer "+s.token {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+	return s.staticDiscoveryServer.DiscoverServers(ctx, req)
+}
+
+func TestTokenSource(t *testing.T) {
+	server := grpc.NewServer()
+	discovery.RegisterDiscoveryServer(server, authDiscoveryServer{token: "secret"})
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(lis)
+	defer server.Stop()
+	port := int32(lis.Addr().(*net.TCPAddr).Port)
+
+	// Tokens are not sent in cleartext unless allowed
+	if _, err := NewClient(WithDiscoveryAddress("127.0.0.1", port), WithTokenSource(StaticToken("secret"))); err == nil {
+		t.Fatalf("Expected an error for a token without TLS")
+	}
+
+	client, err := NewClient(WithDiscoveryAddress("127.0.0.1", port), WithTokenSource(StaticToken("secret")), WithInsecureTokens())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+
+	_, err = NewClient(WithDiscoveryAddress("127.0.0.1", port), WithTokenSource(StaticToken("wrong")), WithInsecureTokens())
+	var authErr *AuthError
+	if !errors.As(err, &authErr) {
+		t.Fatalf("Expected AuthError, Actual: %v", err)
+	}
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("Expected: %s, Actual: %s", codes.Unauthenticated, status.Code(err))
+	}
+}
+
+func TestFileTokenSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "token")
+	source := NewFileTokenSource(path)
+	if _, err := source.Token(context.Background()); !os.IsNotExist(err) {
+		t.Fatalf("Expected: %v, Actual: %v", os.ErrNotExist, err)
+	}
+	if err := ioutil.WriteFile(path, []byte("first\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Now().Add(-time.Minute)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	token, err := source.Token(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "first" {
+		t.Fatalf("Expected: %s, Actual: %s", "first", token)
+	}
+
+	// The file is not reread while its modification time is unchanged
+	if err := ioutil.WriteFile(path, []byte("second\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	token, err = source.Token(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "first" {
+		t.Fatalf("Expected: %s, Actual: %s", "first", token)
+	}
+
+	// The file is reread once it is modified
+	modTime = modTime.Add(time.Second)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	token, err = source.Token(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "second" {
+		t.Fatalf("Expected: %s, Actual: %s", "second", token)
+	}
+}
+
+func TestRefreshingTokenSource(t *testing.T) {
+	refreshes := 0
+	expiry := time.Now().Add(time.Hour)
+	var refreshErr error
+	source := NewRefreshingTokenSource(func(ctx context.Context) (string, time.Time, error) {
+		if refreshErr != nil {
+			return "", time.Time{}, refreshErr
+		}
+		refreshes++
+		return fmt.Sprintf("token-%d", refreshes), expiry, nil
+	})
+	for i := 0; i < 2; i++ {
+		token, err := source.Token(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "token-1" {
+			t.Fatalf("Expected: %s, Actual: %s", "token-1", token)
+		}
+	}
+
+	// Tokens are refreshed once they are about to expire
+	expiry = time.Now().Add(defaultRefreshMargin + 50*time.Millisecond)
+	source.(*refreshingTokenSource).expiry = expiry
+	token, err := source.Token(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "token-1" {
+		t.Fatalf("Expected: %s, Actual: %s", "token-1", token)
+	}
+	time.Sleep(100 * time.Millisecond)
+	token, err = source.Token(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "token-2" {
+		t.Fatalf("Expected: %s, Actual: %s", "token-2", token)
+	}
+
+	// Errors of the refresher are returned once the token is about to expire
+	refreshErr = errors.New("Refresh failed")
+	source.(*refreshingTokenSource).expiry = time.Now()
+	if _, err := source.Token(context.Background()); err != refreshErr {
+		t.Fatalf("Expected: %v, Actual: %v", refreshErr, err)
+	}
+}
@@ -0,0 +1,355 @@
+package lib
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+
+	discovery "github.com/scalog/scalog/discovery/rpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/status"
+)
+
+// BalancerName is the name of the gRPC balancer spreading requests across the
+// replicas of a shard. Connections to shard targets select it through the
+// service config returned by the resolver.
+const BalancerName = "scalog_replica"
+
+func init() {
+	balancer.Register(replicaBalancerBuilder{})
+}
+
+// replicaBalancerBuilder builds replica balancers.
+type replicaBalancerBuilder struct{}
+
+// replicaBalancer is a base balancer that also tracks which replicas are still
+// making their first connection attempt.
+type replicaBalancer struct {
+	balancer.V2Balancer
+	states *replicaStates
+}
+
+// replicaClientConn records the replica of every connection the base balancer
+// creates.
+type replicaClientConn struct {
+	balancer.ClientConn
+	states *replicaStates
+}
+
+// replicaStates tracks the connections to the replicas of a shard.
+type replicaStates struct {
+	// Map from connection to the server identifier of its replica
+	servers map[balancer.SubConn]int32
+	// Set of connections making their first connection attempt
+	connecting map[balancer.SubConn]bool
+	// Mutex for accessing servers and connecting
+	mu sync.Mutex
+}
+
+// replicaPickerBuilder builds replica pickers from the ready connections to the
+// replicas of a shard.
+type replicaPickerBuilder struct {
+	states *replicaStates
+}
+
+// replicaPicker picks the replica a request is pinned to with withReplica, or
+// otherwise the less loaded of two random replicas, measuring load by the
+// number of outstanding requests, and records it in the pickedReplica of the
+// request if any.
+type replicaPicker struct {
+	replicas []*replicaConn
+	// Map from server identifier to the connection to the replica
+	servers map[int32]*replicaConn
+	// Connections of the shard, including those that are not ready
+	states *replicaStates
+}
+
+// replicaKey is the context key of the server identifier of the replica a
+// request is pinned to.
+type replicaKey struct{}
+
+// pickedKey is the context key of the pickedReplica of a request left to the
+// replica balancer.
+type pickedKey struct{}
+
+// pickedReplica records the replica picked by the replica balancer for a
+// request that is not pinned to one.
+type pickedReplica struct {
+	// Server identifier of the replica picked, or -1 if none has been
+	serverID int32
+}
+
+// replicaConn is a connection to a replica and its load.
+type replicaConn struct {
+	// Connection to the replica
+	subConn balancer.SubConn
+	// Server identifier of the replica, or -1 if unknown
+	serverID int32
+	// Number of requests sent on the connection that have not completed
+	outstanding int64
+}
+
+// Build returns a replica balancer on top of a base balancer.
+func (replicaBalancerBuilder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	states := &replicaStates{
+		servers:    make(map[balancer.SubConn]int32),
+		connecting: make(map[balancer.SubConn]bool),
+	}
+	builder := base.NewBalancerBuilderWithConfig(BalancerName, &replicaPickerBuilder{states: states}, base.Config{})
+	b := builder.Build(&replicaClientConn{ClientConn: cc, states: states}, opts)
+	return &replicaBalancer{V2Balancer: b.(balancer.V2Balancer), states: states}
+}
+
+// Name returns the name of the replica balancer.
+func (replicaBalancerBuilder) Name() string {
+	return BalancerName
+}
+
+// HandleSubConnStateChange is not called, since gRPC calls UpdateSubConnState
+// instead.
+func (b *replicaBalancer) HandleSubConnStateChange(sc balancer.SubConn, state connectivity.State) {
+	b.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: state})
+}
+
+// HandleResolvedAddrs is not called, since gRPC calls UpdateResolverState
+// instead.
+func (b *replicaBalancer) HandleResolvedAddrs(addrs []resolver.Address, err error) {
+	if err == nil {
+		b.UpdateResolverState(resolver.State{Addresses: addrs})
+	}
+}
+
+// UpdateSubConnState records the state of a connection before passing it to
+// the base balancer, which publishes a picker on every change.
+func (b *replicaBalancer) UpdateSubConnState(sc balancer.SubConn, state balancer.SubConnState) {
+	b.states.update(sc, state.ConnectivityState)
+	b.V2Balancer.UpdateSubConnState(sc, state)
+}
+
+// NewSubConn creates a connection and records the replica it connects to.
+func (cc *replicaClientConn) NewSubConn(addrs []resolver.Address, opts balancer.NewSubConnOptions) (balancer.SubConn, error) {
+	sc, err := cc.ClientConn.NewSubConn(addrs, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) > 0 {
+		if serverID, ok := addrs[0].Metadata.(int32); ok {
+			cc.states.add(sc, serverID)
+		}
+	}
+	return sc, nil
+}
+
+// add records a new connection to the replica with a server identifier.
+func (s *replicaStates) add(sc balancer.SubConn, serverID int32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.servers[sc] = serverID
+	s.connecting[sc] = true
+}
+
+// update records the state of a connection. A connection makes its first
+// connection attempt until it is ready or fails.
+func (s *replicaStates) update(sc balancer.SubConn, state connectivity.State) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch state {
+	case connectivity.Ready, connectivity.TransientFailure:
+		delete(s.connecting, sc)
+	case connectivity.Shutdown:
+		delete(s.connecting, sc)
+		delete(s.servers, sc)
+	}
+}
+
+// isConnecting returns whether a connection to the replica with a server
+// identifier is making its first connection attempt.
+func (s *replicaStates) isConnecting(serverID int32) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for sc := range s.connecting {
+		if s.servers[sc] == serverID {
+			return true
+		}
+	}
+	return false
+}
+
+// Build returns a picker of the ready connections.
+func (pb *replicaPickerBuilder) Build(readySCs map[resolver.Address]balancer.SubConn) balancer.Picker {
+	p := &replicaPicker{
+		replicas: make([]*replicaConn, 0, len(readySCs)),
+		servers:  make(map[int32]*replicaConn),
+		states:   pb.states,
+	}
+	for addr, sc := range readySCs {
+		replica := &replicaConn{subConn: sc, serverID: -1}
+		p.replicas = append(p.replicas, replica)
+		if serverID, ok := addr.Metadata.(int32); ok {
+			replica.serverID = serverID
+			p.servers[serverID] = replica
+		}
+	}
+	return p
+}
+
+// Pick returns the connection of the replica the request is pinned to, or of
+// the less loaded of two random replicas. Requests pinned to a replica that is
+// making its first connection attempt wait for it, and requests pinned to
+// another replica that is not connected fail with codes.Unavailable, so that
+// the caller may pick another replica.
+func (p *replicaPicker) Pick(ctx context.Context, opts balancer.PickOptions) (balancer.SubConn, func(balancer.DoneInfo), error) {
+	var replica *replicaConn
+	if serverID, ok := ctx.Value(replicaKey{}).(int32); ok {
+		replica = p.servers[serverID]
+		if replica == nil {
+			if p.states.isConnecting(serverID) {
+				return nil, nil, balancer.ErrNoSubConnAvailable
+			}
+			return nil, nil, status.Errorf(codes.Unavailable, "Replica %d is not connected", serverID)
+		}
+	} else if len(p.replicas) == 0 {
+		return nil, nil, balancer.ErrNoSubConnAvailable
+	} else if len(p.replicas) == 1 {
+		replica = p.replicas[0]
+	} else {
+		i := rand.Intn(len(p.replicas))
+		j := rand.Intn(len(p.replicas) - 1)
+		if j >= i {
+			j++
+		}
+		replica = p.replicas[i]
+		if atomic.LoadInt64(&p.replicas[j].outstanding) < atomic.LoadInt64(&replica.outstanding) {
+			replica = p.replicas[j]
+		}
+	}
+	if picked, ok := ctx.Value(pickedKey{}).(*pickedReplica); ok {
+		atomic.StoreInt32(&picked.serverID, replica.serverID)
+	}
+	atomic.AddInt64(&replica.outstanding, 1)
+	return replica.subConn, func(balancer.DoneInfo) {
+		atomic.AddInt64(&replica.outstanding, -1)
+	}, nil
+}
+
+// WithBalancer sends the appends and reads of a shard over a single gRPC
+// connection per shard, whose target is resolved from the client's view by
+// the scalog resolver and served by the replica balancer, instead of dialing
+// a replica for every request. While every replica of a shard is healthy and
+// in the client's zone, or no zone is set, the balancer sends each request to
+// the less loaded of two random replicas. Otherwise, and when a request is
+// retried or hedged, the client picks the replica as without the balancer and
+// pins the request to it. Subscriptions and trims are still sent to every
+// replica.
+//
+// The balancer is opt-in because a balanced client keeps a connection open to
+// every replica of each shard it sends requests to until it is closed, which
+// suits long-lived clients sending many requests, whereas other clients dial
+// the replica of each request and close the connection once it completes.
+func WithBalancer() Option {
+	return func(c *Client) error {
+		c.balanced = true
+		return nil
+	}
+}
+
+// setView replaces the client's view and publishes it to the resolvers of the
+// client's shard connections. The caller must hold viewMu or be the only user
+// of the client.
+func (c *Client) setView(view []*discovery.Shard) {
+	c.view = view
+	if c.viewPublisher != nil {
+		c.viewPublisher.publish(view)
+	}
+}
+
+// shardConn returns the balanced connection to a shard, dialing it if needed.
+func (c *Client) shardConn(shardID int32) (*grpc.ClientConn, error) {
+	c.shardConnsMu.Lock()
+	defer c.shardConnsMu.Unlock()
+	if conn, in := c.shardConns[shardID]; in {
+		return conn, nil
+	}
+	conn, err := c.dial(ShardTarget(c.viewPublisher.authority, shardID))
+	if err != nil {
+		return nil, err
+	}
+	c.shardConns[shardID] = conn
+	return conn, nil
+}
+
+// closeShardConns closes the balanced connections to shards, so that they are
+// dialed again with the client's current settings.
+func (c *Client) closeShardConns() {
+	c.shardConnsMu.Lock()
+	defer c.shardConnsMu.Unlock()
+	for shardID, conn := range c.shardConns {
+		conn.Close()
+		delete(c.shardConns, shardID)
+	}
+}
+
+// pickReplica returns the context with which to send a request to a shard and
+// the server to send it to, or false if every server has been tried. On the
+// first attempt of a request of a balanced client, the server is nil if every
+// replica is available and in the client's zone, or no zone is set, and the
+// replica balancer picks the replica, which pickedServer then returns.
+// Otherwise the server is picked by pickServer.
+func (c *Client) pickReplica(ctx context.Context, shard *discovery.Shard, tried map[*discovery.DataServer]bool, first bool) (context.Context, *discovery.DataServer, bool) {
+	if first && c.balancesReplicas(shard) {
+		return context.WithValue(ctx, pickedKey{}, &pickedReplica{serverID: -1}), nil, true
+	}
+	server := c.pickServer(shard, tried)
+	return ctx, server, server != nil
+}
+
+// balancesReplicas returns whether the replica balancer may pick the replica of
+// a request to a shard, which is the case if the client is balanced and would
+// pick any replica of the shard.
+func (c *Client) balancesReplicas(shard *discovery.Shard) bool {
+	if !c.balanced {
+		return false
+	}
+	config := c.getConfig()
+	for _, server := range shard.Servers {
+		if !c.health.available(shard.ShardID, server.ServerID) {
+			return false
+		}
+		if config.Zone != "" && config.zoneOf(server) != config.Zone {
+			return false
+		}
+	}
+	return true
+}
+
+// pickedServer returns the server of a shard a request sent with ctx was sent
+// to: server if the client picked it, or else the replica picked by the
+// replica balancer, or nil if the balancer picked none.
+func pickedServer(ctx context.Context, shard *discovery.Shard, server *discovery.DataServer) *discovery.DataServer {
+	picked, ok := ctx.Value(pickedKey{}).(*pickedReplica)
+	if server != nil || !ok {
+		return server
+	}
+	serverID := atomic.LoadInt32(&picked.serverID)
+	for _, s := range shard.Servers {
+		if s.ServerID == serverID {
+			return s
+		}
+	}
+	return nil
+}
+
+// withReplica returns a context pinning the requests sent with it over a
+// balanced connection to the replica with a server identifier.
+func withReplica(ctx context.Context, serverID int32) context.Context {
+	return context.WithValue(ctx, replicaKey{}, serverID)
+}
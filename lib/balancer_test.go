@@ -0,0 +1,225 @@
+package lib
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/scalog/scalog-client/scalogtest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/status"
+)
+
+// fakeSubConn is a balancer.SubConn that is never connected.
+type fakeSubConn struct {
+	balancer.SubConn
+	name string
+}
+
+func TestReplicaPicker(t *testing.T) {
+	first, second := &fakeSubConn{name: "first"}, &fakeSubConn{name: "second"}
+	picker := (&replicaPickerBuilder{}).Build(map[resolver.Address]balancer.SubConn{
+		{Addr: "127.0.0.1:26000"}: first,
+		{Addr: "127.0.0.1:26001"}: second,
+	})
+	picked, done, err := picker.Pick(context.Background(), balancer.PickOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The replica with an outstanding request is avoided
+	for i := 0; i < 10; i++ {
+		next, nextDone, err := picker.Pick(context.Background(), balancer.PickOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next == picked {
+			t.Fatalf("Expected: %v, Actual: %v", "other replica", next.(*fakeSubConn).name)
+		}
+		nextDone(balancer.DoneInfo{})
+	}
+	done(balancer.DoneInfo{})
+
+	// Pinned requests go to their replica, or fail if it is not connected
+	picker = (&replicaPickerBuilder{}).Build(map[resolver.Address]balancer.SubConn{
+		{Addr: "127.0.0.1:26000", Metadata: int32(0)}: first,
+		{Addr: "127.0.0.1:26001", Metadata: int32(1)}: second,
+	})
+	for i := 0; i < 10; i++ {
+		next, nextDone, err := picker.Pick(withReplica(context.Background(), 1), balancer.PickOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next != second {
+			t.Fatalf("Expected: %v, Actual: %v", "second", next.(*fakeSubConn).name)
+		}
+		nextDone(balancer.DoneInfo{})
+	}
+	if _, _, err := picker.Pick(withReplica(context.Background(), 2), balancer.PickOptions{}); status.Code(err) != codes.Unavailable {
+		t.Fatalf("Expected: %v, Actual: %v", codes.Unavailable, err)
+	}
+
+	// The replica picked for a request that is not pinned is recorded
+	record := &pickedReplica{serverID: -1}
+	next, nextDone, err := picker.Pick(context.WithValue(context.Background(), pickedKey{}, record), balancer.PickOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nextDone(balancer.DoneInfo{})
+	if expected := map[balancer.SubConn]int32{first: 0, second: 1}[next]; record.serverID != expected {
+		t.Fatalf("Expected: %d, Actual: %d", expected, record.serverID)
+	}
+
+	// Requests pinned to a replica making its first connection attempt wait
+	states := &replicaStates{
+		servers:    make(map[balancer.SubConn]int32),
+		connecting: make(map[balancer.SubConn]bool),
+	}
+	third := &fakeSubConn{name: "third"}
+	states.add(third, 2)
+	picker = (&replicaPickerBuilder{states: states}).Build(map[resolver.Address]balancer.SubConn{})
+	if _, _, err := picker.Pick(withReplica(context.Background(), 2), balancer.PickOptions{}); err != balancer.ErrNoSubConnAvailable {
+		t.Fatalf("Expected: %v, Actual: %v", balancer.ErrNoSubConnAvailable, err)
+	}
+	states.update(third, connectivity.TransientFailure)
+	if _, _, err := picker.Pick(withReplica(context.Background(), 2), balancer.PickOptions{}); status.Code(err) != codes.Unavailable {
+		t.Fatalf("Expected: %v, Actual: %v", codes.Unavailable, err)
+	}
+}
+
+func TestClientBalancer(t *testing.T) {
+	cluster := newTestCluster(t)
+	defer cluster.Close()
+	client := newTestClient(t, cluster, WithBalancer())
+	defer client.Close()
+	gsn, shardID, err := client.AppendToShard("Hello, World!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := client.ReadRecord(gsn, shardID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record != "Hello, World!" {
+		t.Fatalf("Expected: %s, Actual: %s", "Hello, World!", record)
+	}
+	if len(client.shardConns) != 1 {
+		t.Fatalf("Expected: %d, Actual: %d", 1, len(client.shardConns))
+	}
+}
+
+func TestClientBalancerPicksReplicas(t *testing.T) {
+	cluster := newTestCluster(t)
+	defer cluster.Close()
+	var local, remote *scalogtest.Server
+	for _, server := range cluster.Servers() {
+		if server.ShardID() != 0 {
+			continue
+		}
+		if local == nil {
+			local = server
+		} else {
+			remote = server
+		}
+	}
+	client := newTestClient(t, cluster,
+		WithBalancer(),
+		WithZone("a", Locality{Zone: "a", ServerIDs: []int32{local.ServerID()}}),
+		WithCircuitBreaker(1, time.Minute),
+	)
+	defer client.Close()
+	shard := client.getShard(0)
+	gsn, err := client.appendToShard(shard, 0, "Hello, World!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Reads go to the replica in the client's zone
+	for i := 0; i < 4; i++ {
+		if _, err := client.ReadRecord(gsn, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if health := client.health.get(0, local.ServerID()); health.LastUpdated.IsZero() {
+		t.Fatalf("Expected requests to server %d, Actual: %+v", local.ServerID(), health)
+	}
+	if health := client.health.get(0, remote.ServerID()); !health.LastUpdated.IsZero() {
+		t.Fatalf("Expected no requests to server %d, Actual: %+v", remote.ServerID(), health)
+	}
+	// Reads fail over to the other zone once the local replica fails
+	local.SetFault(scalogtest.Fault{Unavailable: true})
+	for i := 0; i < 4; i++ {
+		if _, err := client.ReadRecord(gsn, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if health := client.health.get(0, local.ServerID()); health.State != ServerUnhealthy {
+		t.Fatalf("Expected: %v, Actual: %+v", ServerUnhealthy, health)
+	}
+	if health := client.health.get(0, remote.ServerID()); health.LastUpdated.IsZero() {
+		t.Fatalf("Expected requests to server %d, Actual: %+v", remote.ServerID(), health)
+	}
+}
+
+func TestClientBalancerSpreadsRequests(t *testing.T) {
+	cluster := newTestCluster(t)
+	defer cluster.Close()
+	var pinned, unpinned int32
+	interceptor := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if strings.HasSuffix(method, "/Read") {
+			if _, ok := ctx.Value(replicaKey{}).(int32); ok {
+				atomic.AddInt32(&pinned, 1)
+			} else {
+				atomic.AddInt32(&unpinned, 1)
+			}
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	client := newTestClient(t, cluster,
+		WithBalancer(),
+		WithCircuitBreaker(1, time.Minute),
+		WithUnaryInterceptors(interceptor),
+	)
+	defer client.Close()
+	shard := client.getShard(0)
+	gsn, err := client.appendToShard(shard, 0, "Hello, World!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Reads are left to the balancer, which spreads them across replicas, and
+	// the health of the replica picked is tracked
+	for i := 0; i < 20; i++ {
+		if _, err := client.ReadRecord(gsn, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if p, u := atomic.LoadInt32(&pinned), atomic.LoadInt32(&unpinned); p != 0 || u != 20 {
+		t.Fatalf("Expected: %d unpinned reads, Actual: %d pinned and %d unpinned", 20, p, u)
+	}
+	for _, server := range shard.Servers {
+		if health := client.health.get(0, server.ServerID); health.LastUpdated.IsZero() {
+			t.Fatalf("Expected requests to server %d, Actual: %+v", server.ServerID, health)
+		}
+	}
+	// Reads are pinned to the healthy replica once the other fails
+	failed, err := cluster.Server(shard.Servers[0].ServerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed.SetFault(scalogtest.Fault{Unavailable: true})
+	for i := 0; i < 20; i++ {
+		if _, err := client.ReadRecord(gsn, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if health := client.health.get(0, failed.ServerID()); health.State != ServerUnhealthy {
+		t.Fatalf("Expected: %v, Actual: %+v", ServerUnhealthy, health)
+	}
+	if atomic.LoadInt32(&pinned) == 0 {
+		t.Fatalf("Expected pinned reads once a replica failed")
+	}
+}
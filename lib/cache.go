@@ -0,0 +1,165 @@
+package lib
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+)
+
+// CacheStats contains statistics about a client's read cache.
+type CacheStats struct {
+	// Number of reads served from the cache
+	Hits int64
+	// Number of reads not served from the cache
+	Misses int64
+	// Number of records evicted to stay within the size bound
+	Evictions int64
+	// Number of records in the cache
+	Entries int
+	// Total size in bytes of the records in the cache
+	Bytes int64
+}
+
+// cacheEntry represents a committed record held in the read cache.
+type cacheEntry struct {
+	// Global sequence number of the record
+	gsn int32
+	// Identifier of the shard the record was read from
+	shardID int32
+	// Data of record
+	record string
+}
+
+// recordCache is an LRU cache of committed records bounded by the total size
+// of the records it holds. Committed records are immutable, so entries only
+// need to be invalidated when they are trimmed.
+type recordCache struct {
+	// Maximum total size in bytes of the cached records
+	maxBytes int64
+	// Total size in bytes of the cached records
+	bytes int64
+	// Map from global sequence number to element of order
+	entries map[int32]*list.Element
+	// List of cacheEntries from most to least recently used
+	order *list.List
+	// Global sequence number before which records have been trimmed and are
+	// not inserted
+	trimmed int32
+	// Statistics about the cache
+	hits      int64
+	misses    int64
+	evictions int64
+	// Mutex for accessing the cache
+	mu sync.Mutex
+}
+
+// WithReadCache enables an LRU cache in front of ReadRecord that holds at most
+// maxBytes bytes of records.
+func WithReadCache(maxBytes int64) Option {
+	return func(c *Client) error {
+		if maxBytes <= 0 {
+			return fmt.Errorf("Read cache size must be greater than 0")
+		}
+		c.cache = newRecordCache(maxBytes)
+		return nil
+	}
+}
+
+// CacheStats returns statistics about the read cache. All statistics are zero
+// if the client was not created with WithReadCache.
+func (c *Client) CacheStats() CacheStats {
+	if c.cache == nil {
+		return CacheStats{}
+	}
+	return c.cache.stats()
+}
+
+// newRecordCache returns a new instance of recordCache.
+func newRecordCache(maxBytes int64) *recordCache {
+	return &recordCache{
+		maxBytes: maxBytes,
+		entries:  make(map[int32]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// get returns the record with a global sequence number and the identifier of
+// the shard it was read from, and whether the record was in the cache. If
+// shardID is not -1, a record read from another shard is a miss.
+func (rc *recordCache) get(gsn int32, shardID int32) (string, int32, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	elem, in := rc.entries[gsn]
+	if !in || (shardID != -1 && elem.Value.(*cacheEntry).shardID != shardID) {
+		rc.misses++
+		return "", -1, false
+	}
+	rc.hits++
+	rc.order.MoveToFront(elem)
+	entry := elem.Value.(*cacheEntry)
+	return entry.record, entry.shardID, true
+}
+
+// add inserts a record into the cache, evicting the least recently used
+// records as needed. Records larger than the cache and records that have been
+// trimmed are not inserted.
+func (rc *recordCache) add(gsn int32, shardID int32, record string) {
+	size := int64(len(record))
+	if size > rc.maxBytes {
+		return
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if gsn < rc.trimmed {
+		return
+	}
+	if elem, in := rc.entries[gsn]; in {
+		rc.order.MoveToFront(elem)
+		return
+	}
+	rc.entries[gsn] = rc.order.PushFront(&cacheEntry{
+		gsn:     gsn,
+		shardID: shardID,
+		record:  record,
+	})
+	rc.bytes += size
+	for rc.bytes > rc.maxBytes {
+		rc.remove(rc.order.Back())
+		rc.evictions++
+	}
+}
+
+// trim invalidates the records before a global sequence number, and prevents
+// reads that started before the trim from inserting them again.
+func (rc *recordCache) trim(gsn int32) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if gsn > rc.trimmed {
+		rc.trimmed = gsn
+	}
+	for entryGsn, elem := range rc.entries {
+		if entryGsn < gsn {
+			rc.remove(elem)
+		}
+	}
+}
+
+// stats returns statistics about the cache.
+func (rc *recordCache) stats() CacheStats {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return CacheStats{
+		Hits:      rc.hits,
+		Misses:    rc.misses,
+		Evictions: rc.evictions,
+		Entries:   len(rc.entries),
+		Bytes:     rc.bytes,
+	}
+}
+
+// remove deletes an element from the cache. The caller must hold mu.
+func (rc *recordCache) remove(elem *list.Element) {
+	entry := rc.order.Remove(elem).(*cacheEntry)
+	delete(rc.entries, entry.gsn)
+	rc.bytes -= int64(len(entry.record))
+}
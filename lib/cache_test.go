@@ -0,0 +1,59 @@
+package lib
+
+import (
+	"testing"
+)
+
+func TestRecordCacheEviction(t *testing.T) {
+	cache := newRecordCache(10)
+	cache.add(1, 0, "aaaa")
+	cache.add(2, 0, "bbbb")
+	if _, _, in := cache.get(1, 0); !in {
+		t.Fatalf("Expected record 1 to be cached")
+	}
+	cache.add(3, 0, "cccc")
+	if _, _, in := cache.get(2, 0); in {
+		t.Fatalf("Expected least recently used record 2 to be evicted")
+	}
+	record, shardID, in := cache.get(3, -1)
+	if !in || record != "cccc" || shardID != 0 {
+		t.Fatalf("Expected: cccc, Actual: %s", record)
+	}
+	stats := cache.stats()
+	if stats.Hits != 2 || stats.Misses != 1 || stats.Evictions != 1 || stats.Entries != 2 || stats.Bytes != 8 {
+		t.Fatalf("Unexpected cache stats %+v", stats)
+	}
+}
+
+func TestRecordCacheTrim(t *testing.T) {
+	cache := newRecordCache(1024)
+	for gsn := int32(0); gsn < 8; gsn++ {
+		cache.add(gsn, 0, "Hello, World!")
+	}
+	cache.trim(5)
+	for gsn := int32(0); gsn < 8; gsn++ {
+		_, _, in := cache.get(gsn, 0)
+		if in != (gsn >= 5) {
+			t.Fatalf("Unexpected cache membership %t for record %d after trim", in, gsn)
+		}
+	}
+	if stats := cache.stats(); stats.Entries != 3 {
+		t.Fatalf("Expected: %d, Actual: %d", 3, stats.Entries)
+	}
+	// A read that started before the trim does not insert a trimmed record
+	cache.add(4, 0, "Hello, World!")
+	if _, _, in := cache.get(4, 0); in {
+		t.Fatalf("Expected trimmed record 4 not to be cached")
+	}
+}
+
+func TestRecordCacheOtherShard(t *testing.T) {
+	cache := newRecordCache(1024)
+	cache.add(1, 0, "Hello, World!")
+	if _, _, in := cache.get(1, 1); in {
+		t.Fatalf("Expected record read from shard 0 to miss for shard 1")
+	}
+	if stats := cache.stats(); stats.Hits != 0 || stats.Misses != 1 {
+		t.Fatalf("Unexpected cache stats %+v", stats)
+	}
+}
@@ -0,0 +1,226 @@
+package lib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// CheckpointStore persists the positions of named durable subscriptions.
+type CheckpointStore interface {
+	// Load returns the global sequence number from which the subscription
+	// with a name resumes, and whether a checkpoint of it exists.
+	Load(name string) (int32, bool, error)
+	// Save stores the global sequence number from which the subscription
+	// with a name resumes.
+	Save(name string, gsn int32) error
+}
+
+// MemoryCheckpointStore is a CheckpointStore that keeps checkpoints in memory,
+// for tests and for subscriptions that need not survive the process.
+type MemoryCheckpointStore struct {
+	// Map from subscription name to the global sequence number it resumes
+	// from
+	checkpoints map[string]int32
+	// Mutex for accessing checkpoints
+	mu sync.Mutex
+}
+
+// FileCheckpointStore is a CheckpointStore that keeps checkpoints in a local
+// YAML file, rewritten atomically on every save.
+type FileCheckpointStore struct {
+	// Path of the file in which the checkpoints are persisted
+	path string
+	// Map from subscription name to the global sequence number it resumes
+	// from
+	checkpoints map[string]int32
+	// Mutex for accessing checkpoints and the file
+	mu sync.Mutex
+}
+
+// DurableSubscription is a named subscription whose position is checkpointed
+// in a CheckpointStore, so that a restarted consumer resumes where it stopped.
+// Records are marked processed with MarkProcessed, and the position after the
+// last processed record is saved by Commit, periodically if a commit interval
+// is set, and by Close. Records processed but not committed are delivered
+// again after a restart, so delivery is at-least-once.
+type DurableSubscription struct {
+	// Name of the subscription in the store
+	name string
+	// Store of the subscription's checkpoint
+	store CheckpointStore
+	// Client delivering the records
+	client *Client
+	// Channel on which records are delivered
+	records chan CommittedRecord
+	// Global sequence number after the last processed record
+	processed int32
+	// Global sequence number saved by the last commit
+	committed int32
+	// Mutex for accessing processed and committed
+	mu sync.Mutex
+	// Channel closed when the subscription is closed
+	done chan struct{}
+	// Ensures the subscription is closed only once
+	closeOnce sync.Once
+}
+
+// NewMemoryCheckpointStore returns a new instance of MemoryCheckpointStore.
+func NewMemoryCheckpointStore() *MemoryCheckpointStore {
+	return &MemoryCheckpointStore{checkpoints: make(map[string]int32)}
+}
+
+// Load returns the checkpoint of a subscription.
+func (s *MemoryCheckpointStore) Load(name string) (int32, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	gsn, in := s.checkpoints[name]
+	return gsn, in, nil
+}
+
+// Save stores the checkpoint of a subscription.
+func (s *MemoryCheckpointStore) Save(name string, gsn int32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checkpoints[name] = gsn
+	return nil
+}
+
+// NewFileCheckpointStore returns a FileCheckpointStore persisting checkpoints
+// in the file at path, reading the checkpoints already in it if it exists.
+func NewFileCheckpointStore(path string) (*FileCheckpointStore, error) {
+	s := &FileCheckpointStore{path: path, checkpoints: make(map[string]int32)}
+	file, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = yaml.Unmarshal(file, &s.checkpoints)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse checkpoints %s: %v", path, err)
+	}
+	return s, nil
+}
+
+// Load returns the checkpoint of a subscription.
+func (s *FileCheckpointStore) Load(name string) (int32, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	gsn, in := s.checkpoints[name]
+	return gsn, in, nil
+}
+
+// Save stores the checkpoint of a subscription and rewrites the file.
+func (s *FileCheckpointStore) Save(name string, gsn int32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checkpoints[name] = gsn
+	out, err := yaml.Marshal(s.checkpoints)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, out)
+}
+
+// SubscribeDurable subscribes to records from the checkpoint of the
+// subscription with a name in a store, or from a global sequence number if the
+// store has no checkpoint of it. If commitInterval is greater than 0, the
+// position after the last processed record is committed at that interval. Like
+// Subscribe, SubscribeDurable may be called once per client.
+func (c *Client) SubscribeDurable(name string, store CheckpointStore, gsn int32, commitInterval time.Duration) (*DurableSubscription, error) {
+	checkpoint, in, err := store.Load(name)
+	if err != nil {
+		return nil, err
+	}
+	if in {
+		gsn = checkpoint
+	}
+	records, err := c.Subscribe(gsn)
+	if err != nil {
+		return nil, err
+	}
+	c.logger.Info("Resuming durable subscription", "name", name, "gsn", gsn, "checkpointed", in)
+	s := &DurableSubscription{
+		name:      name,
+		store:     store,
+		client:    c,
+		records:   records,
+		processed: gsn,
+		committed: gsn,
+		done:      make(chan struct{}),
+	}
+	if commitInterval > 0 {
+		go s.commitPeriodically(commitInterval)
+	}
+	return s, nil
+}
+
+// Name returns the name of the subscription.
+func (s *DurableSubscription) Name() string {
+	return s.name
+}
+
+// Records returns the channel on which records are delivered in order of
+// global sequence number.
+func (s *DurableSubscription) Records() <-chan CommittedRecord {
+	return s.records
+}
+
+// MarkProcessed marks the records up to and including a global sequence number
+// as processed, so that the next commit skips them after a restart.
+func (s *DurableSubscription) MarkProcessed(gsn int32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if gsn+1 > s.processed {
+		s.processed = gsn + 1
+	}
+}
+
+// Commit saves the position after the last processed record to the store.
+func (s *DurableSubscription) Commit() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.processed == s.committed {
+		return nil
+	}
+	err := s.store.Save(s.name, s.processed)
+	if err != nil {
+		return err
+	}
+	s.committed = s.processed
+	return nil
+}
+
+// Close stops periodic commits and commits the last processed record. Records
+// are delivered until the client is closed.
+func (s *DurableSubscription) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+	return s.Commit()
+}
+
+// commitPeriodically commits at an interval until the subscription or its
+// client is closed.
+func (s *DurableSubscription) commitPeriodically(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.client.done:
+			return
+		case <-ticker.C:
+		}
+		if err := s.Commit(); err != nil {
+			s.client.logger.Warn("Failed to commit durable subscription", "name", s.name, "err", err)
+		}
+	}
+}
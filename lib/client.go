@@ -4,20 +4,30 @@ package lib
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"hash/fnv"
 	"io"
-	"io/ioutil"
 	"math/rand"
+	"net"
+	"strconv"
 	"sync"
 	"time"
 
-	"gopkg.in/yaml.v2"
-
 	data "github.com/scalog/scalog/data/messaging"
 	discovery "github.com/scalog/scalog/discovery/rpc"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
 )
 
+// unknownViewID is the version of a view obtained from the discovery service,
+// which does not report versions. The version is learned from the next data
+// server response, which differs from it and queries the discovery service
+// again.
+const unknownViewID int32 = -1
+
 // CommittedRecord represents a record that has been commited by Scalog.
 type CommittedRecord struct {
 	// Global sequence number assigned by Scalog
@@ -39,6 +49,8 @@ type Client struct {
 	appendMu sync.RWMutex
 	// Global sequence number of next CommitedRecord to respond to if subscribed
 	nextGsn int32
+	// Largest global sequence number received if subscribed
+	maxReceivedGsn int32
 	// Map from global sequence number to CommittedRecord
 	committedRecords map[int32]CommittedRecord
 	// Mutex for accessing nextGsn and commitedRecords
@@ -47,30 +59,101 @@ type Client struct {
 	subscribeChan chan CommittedRecord
 	// Function that determines which records are appended to which shards
 	shardPolicy ShardPolicy
-	// Version of the client's view
+	// Version of the client's view, or unknownViewID if it has not been
+	// learned since the view was last replaced
 	viewID int32
 	// Slice of live data servers grouped by shard.
 	view []*discovery.Shard
 	// Mutex for accessing viewID and view
 	viewMu sync.RWMutex
+	// Whether the discovery service is being queried for a view change
+	viewUpdating bool
+	// View identifier for which the discovery service was last queried
+	// unsuccessfully
+	failedViewID int32
+	// Time before which the discovery service is not queried again for
+	// failedViewID, or the zero time if the last query succeeded
+	viewRetryAt time.Time
+	// Delay before the discovery service is queried again after the next
+	// failure, doubled after every consecutive failure
+	viewBackoff time.Duration
+	// Mutex for accessing viewUpdating, failedViewID, viewRetryAt and
+	// viewBackoff
+	viewUpdateMu sync.Mutex
 	// Configuration meta-data specified in config.yaml
 	config *config
+	// TLS configuration for connections to Scalog, or nil if connections are
+	// insecure
+	tlsConfig *tls.Config
+	// Whether tlsConfig was built from the TLS settings of config rather than
+	// given with WithTLSConfig
+	tlsFromConfig bool
+	// Mutex for accessing config, tlsConfig and shardPolicy, which are
+	// replaced when the configuration is reloaded
+	configMu sync.RWMutex
+	// Path of the configuration file reloaded by ReloadConfig, or empty for
+	// ./config.yaml
+	configPath string
+	// Whether the configuration file is watched for changes
+	watchConfig bool
+	// Function called with the outcome of every reload, or nil if none
+	reportReload func(err error)
+	// Source of the bearer token attached to requests, or nil if none
+	tokenSource TokenSource
+	// Whether the bearer token may be sent over connections without TLS
+	insecureTokens bool
+	// Receiver of measurements of the client's activity
+	metrics Metrics
+	// Receiver of the client's log events
+	logger Logger
+	// Interceptors added to every connection the client creates
+	unaryInterceptors  []grpc.UnaryClientInterceptor
+	streamInterceptors []grpc.StreamClientInterceptor
+	// Durable producer state, or nil if the client is not an exactly-once
+	// producer
+	producer *producerState
+	// Cache of records read by ReadRecord, or nil if caching is disabled
+	cache *recordCache
+	// Maximum number of records fetched concurrently by ReadRange
+	readParallelism int
+	// Locations of records learned from appends, subscriptions and reads
+	shardIndex *shardIndex
+	// Policy for hedging reads to a second replica, or nil if disabled
+	hedge *hedgePolicy
+	// Health of the data servers observed from requests and probes
+	health *healthTracker
+	// Health of the discovery endpoints observed from view refreshes
+	discoveryHealth *discoveryTracker
+	// Whether appends and reads are sent over balanced shard connections
+	balanced bool
+	// Publisher of the view to the resolvers of the shard connections, or nil
+	// if the client is not balanced
+	viewPublisher *viewPublisher
+	// Map from shard identifier to the balanced connection to the shard
+	shardConns map[int32]*grpc.ClientConn
+	// Mutex for accessing shardConns
+	shardConnsMu sync.Mutex
+	// Interval at which data servers are probed, or 0 if probing is disabled
+	probeInterval time.Duration
+	// Channel closed when the client is closed
+	done chan struct{}
+	// Ensures the client is closed only once
+	closeOnce sync.Once
 }
 
-// address represents an IP address and port number.
-type address struct {
-	IP   string `yaml:"ip"`
-	Port int32  `yaml:"port"`
-}
+// minResubscribeBackoff and maxResubscribeBackoff bound the delay before a
+// failed subscription stream is resubscribed.
+const (
+	minResubscribeBackoff = 100 * time.Millisecond
+	maxResubscribeBackoff = 10 * time.Second
+)
 
-// config contains the meta-data specified in config.yaml.
-type config struct {
-	DiscoveryAddress address `yaml:"discovery-address"`
-}
+// Option configures optional behavior of a Client.
+type Option func(c *Client) error
 
-// NewClient returns a new instance of Client.
-func NewClient() (*Client, error) {
-	config, err := parseConfig()
+// NewClient returns a new instance of Client configured with the given options.
+func NewClient(opts ...Option) (*Client, error) {
+	config, err := parseConfig("")
 	if err != nil {
 		return nil, err
 	}
@@ -86,11 +169,62 @@ func NewClient() (*Client, error) {
 		viewID:           0,
 		viewMu:           sync.RWMutex{},
 		config:           config,
+		readParallelism:  defaultReadParallelism,
+		shardIndex:       newShardIndex(defaultShardIndexSize),
+		health:           newHealthTracker(),
+		discoveryHealth:  newDiscoveryTracker(),
+		metrics:          noopMetrics{},
+		logger:           nopLogger{},
+		done:             make(chan struct{}),
 	}
-	err = c.updateView()
+	for _, opt := range opts {
+		err = opt(c)
+		if err != nil {
+			return nil, err
+		}
+	}
+	c.health.logger = c.logger
+	err = c.config.validate()
 	if err != nil {
 		return nil, err
 	}
+	if c.tlsConfig == nil && c.config.TLS != nil {
+		c.tlsConfig, err = c.config.TLS.build()
+		if err != nil {
+			return nil, err
+		}
+		c.tlsFromConfig = true
+	}
+	if c.tokenSource != nil && c.tlsConfig == nil && !c.insecureTokens {
+		return nil, fmt.Errorf("Bearer tokens require TLS unless WithInsecureTokens is set")
+	}
+	if c.config.ShardPolicy != "" {
+		c.shardPolicy = shardPolicies[c.config.ShardPolicy]
+	}
+	if c.balanced {
+		c.viewPublisher = newViewPublisher()
+		c.shardConns = make(map[int32]*grpc.ClientConn)
+	}
+	if c.startFromViewCache() {
+		go c.refreshView()
+	} else {
+		err = c.updateViewWithRetry(defaultDiscoveryAttempts)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.saveViewCache(c.config)
+	}
+	if c.probeInterval > 0 {
+		go c.probe()
+	}
+	if c.watchConfig {
+		err = c.startConfigWatch()
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
 	return c, nil
 }
 
@@ -108,90 +242,128 @@ func (c *Client) Append(record string) (int32, error) {
 // returns the global sequence number assigned by Scalog and the shard's
 // identifier.
 func (c *Client) AppendToShard(record string) (int32, int32, error) {
-	shard := c.shardPolicy(c.view, record)
-	server := getRandomServerInShard(shard)
-	opts := []grpc.DialOption{grpc.WithInsecure()}
-	// TODO: temporary fix due to discovery service returning server's cluster IP
-	server.Ip = c.config.DiscoveryAddress.IP
-	// TODO: don't dial for every operation. Save the connection and reuse it
-	conn, err := grpc.Dial(getAddressOfServer(server), opts...)
-	if err != nil {
-		return -1, -1, err
+	if c.isClosed() {
+		return -1, -1, &OpError{Op: "Append", ShardID: -1, Gsn: -1, Kind: ErrClosed}
 	}
-	defer conn.Close()
-	dataClient := data.NewDataClient(conn)
-	c.appendMu.Lock()
-	req := &data.AppendRequest{
-		Cid:    c.clientID,
-		Csn:    c.nextCsn,
-		Record: record,
+	view := c.getView()
+	if len(view) == 0 {
+		return -1, -1, &OpError{Op: "Append", ShardID: -1, Gsn: -1, Kind: ErrShardNotFound}
 	}
+	c.configMu.RLock()
+	shard := c.shardPolicy(view, record)
+	c.configMu.RUnlock()
+	c.appendMu.Lock()
+	csn := c.nextCsn
 	c.nextCsn++
 	c.appendMu.Unlock()
-	resp, err := dataClient.Append(context.Background(), req)
+	if c.producer != nil {
+		err := c.producer.begin(csn, shard.ShardID, record)
+		if err != nil {
+			return -1, -1, err
+		}
+	}
+	start := time.Now()
+	gsn, err := c.appendToShard(shard, csn, record)
+	c.metrics.ObserveAppend(shard.ShardID, time.Since(start), err)
 	if err != nil {
 		return -1, -1, err
 	}
-	c.viewMu.Lock()
-	if resp.ViewID != c.viewID {
-		err := c.updateView()
+	c.shardIndex.add(gsn, shard.ShardID)
+	if c.producer != nil {
+		err = c.producer.ack(csn)
 		if err != nil {
 			return -1, -1, err
 		}
-		c.viewID = resp.ViewID
 	}
-	c.viewMu.Lock()
-	return resp.Gsn, shard.ShardID, nil
+	return gsn, shard.ShardID, nil
 }
 
 // Subscribe subscribes to CommitedRecords starting from a global sequence
 // number, and returns a channel on which to read from.
 func (c *Client) Subscribe(gsn int32) (chan CommittedRecord, error) {
+	if c.isClosed() {
+		return nil, &OpError{Op: "Subscribe", ShardID: -1, Gsn: gsn, Kind: ErrClosed}
+	}
 	c.subscribeMu.Lock()
 	c.nextGsn = gsn
 	c.subscribeMu.Unlock()
-	for _, shard := range c.view {
+	for _, shard := range c.getView() {
 		for _, server := range shard.Servers {
-			// TODO: temporary fix due to discovery service returning server's cluster IP
-			server.Ip = c.config.DiscoveryAddress.IP
-			go c.subscribeToServer(server, gsn)
+			go c.subscribeToServer(server, shard.ShardID, gsn)
 		}
 	}
 	return c.subscribeChan, nil
 }
 
-// ReadRecord reads a record with a global sequence number from a shard.
+// ReadRecord reads a record with a global sequence number from a shard. If the
+// client was created with WithReadCache, the record is served from the cache
+// when possible.
 func (c *Client) ReadRecord(gsn int32, shardID int32) (string, error) {
-	for _, shard := range c.view {
+	if c.isClosed() {
+		return "", &OpError{Op: "Read", ShardID: shardID, Gsn: gsn, Kind: ErrClosed}
+	}
+	if c.cache != nil {
+		record, _, in := c.cache.get(gsn, shardID)
+		if in {
+			return record, nil
+		}
+	}
+	for _, shard := range c.getView() {
 		if shard.ShardID == shardID {
-			server := getRandomServerInShard(shard)
-			// TODO: temporary fix due to discovery service returning server's cluster IP
-			server.Ip = c.config.DiscoveryAddress.IP
-			record, err := c.readFromServer(server, gsn)
+			record, err := c.readFromShard(context.Background(), shard, gsn)
 			if err != nil {
 				return "", err
 			}
+			if c.cache != nil {
+				c.cache.add(gsn, shardID, record)
+			}
+			c.shardIndex.add(gsn, shardID)
 			return record, nil
 		}
 	}
-	return "", fmt.Errorf("Attempted to read record from non-existant shard %d", shardID)
+	return "", &OpError{Op: "Read", ShardID: shardID, Gsn: gsn, Kind: ErrShardNotFound}
 }
 
 // Trim deletes records before a global sequence number from the data servers.
 func (c *Client) Trim(gsn int32) error {
-	for _, shard := range c.view {
+	if c.isClosed() {
+		return &OpError{Op: "Trim", ShardID: -1, Gsn: gsn, Kind: ErrClosed}
+	}
+	if c.cache != nil {
+		c.cache.trim(gsn)
+	}
+	c.shardIndex.trim(gsn)
+	for _, shard := range c.getView() {
 		for _, server := range shard.Servers {
-			// TODO: temporary fix due to discovery service returning server's cluster IP
-			server.Ip = c.config.DiscoveryAddress.IP
-			go c.trimFromServer(server, gsn)
+			go func(shardID int32, server *discovery.DataServer) {
+				err := newOpError("Trim", shardID, gsn, c.trimFromServer(server, gsn))
+				if err != nil {
+					c.logger.Warn("Failed to trim data server", "shard", shardID, "server", server.ServerID, "gsn", gsn, "err", err)
+				}
+			}(shard.ShardID, server)
 		}
 	}
 	return nil
 }
 
+// Close stops the client's background activity, such as health probes and
+// subscriptions. Operations on a closed client fail with ErrClosed.
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		if c.viewPublisher != nil {
+			c.closeShardConns()
+			c.viewPublisher.close()
+		}
+	})
+	return nil
+}
+
 // SetShardPolicy sets the policy for determining which records are appended to
 // which shards.
 func (c *Client) SetShardPolicy(shardPolicy ShardPolicy) {
+	c.configMu.Lock()
+	defer c.configMu.Unlock()
 	c.shardPolicy = shardPolicy
 }
 
@@ -201,39 +373,130 @@ func assignClientID() int32 {
 	return rand.New(seed).Int31()
 }
 
+// shardPolicies are the shard policies that may be selected by name with the
+// shard-policy setting.
+var shardPolicies = map[string]ShardPolicy{
+	"random": defaultShardPolicy,
+	"hash":   hashShardPolicy,
+}
+
 // defaultShardPolicy returns a random shard.
 func defaultShardPolicy(shards []*discovery.Shard, record string) *discovery.Shard {
 	seed := rand.NewSource(time.Now().UnixNano())
 	return shards[rand.New(seed).Intn(len(shards))]
 }
 
-// parseConfig initializes and returns an instance of config with the meta-data
-// specified in config.yaml.
-func parseConfig() (*config, error) {
-	file, err := ioutil.ReadFile("./config.yaml")
+// hashShardPolicy returns a shard determined by the hash of the record, so
+// that identical records are appended to the same shard.
+func hashShardPolicy(shards []*discovery.Shard, record string) *discovery.Shard {
+	h := fnv.New32a()
+	h.Write([]byte(record))
+	return shards[h.Sum32()%uint32(len(shards))]
+}
+
+// appendToShard appends a record with a client sequence number to a shard, and
+// returns the global sequence number assigned by Scalog. If a data server
+// cannot be reached, the record is appended to another replica in the shard
+// with the same client sequence number. Appends that time out are not sent to
+// another replica, since the data server may have appended the record, and
+// the record would be committed twice unless replicas deduplicate appends with
+// the same client identifier and client sequence number across each other.
+func (c *Client) appendToShard(shard *discovery.Shard, csn int32, record string) (int32, error) {
+	var err error = &OpError{Op: "Append", ShardID: shard.ShardID, Gsn: -1, Kind: ErrUnavailable}
+	tried := make(map[*discovery.DataServer]bool)
+	for attempt := 0; ; attempt++ {
+		ctx, server, ok := c.pickReplica(context.Background(), shard, tried, attempt == 0)
+		if !ok {
+			return -1, err
+		}
+		var gsn int32
+		gsn, err = c.appendToServer(ctx, shard.ShardID, server, csn, record)
+		if server = pickedServer(ctx, shard, server); server != nil {
+			tried[server] = true
+			c.health.report(shard.ShardID, server.ServerID, err)
+		}
+		err = newOpError("Append", shard.ShardID, -1, err)
+		if err == nil || status.Code(err) != codes.Unavailable {
+			return gsn, err
+		}
+		c.logger.Warn("Retrying append on another replica", "shard", shard.ShardID, "server", serverIDOf(server), "csn", csn, "err", err)
+	}
+}
+
+// appendToServer appends a record with a client sequence number to a data
+// server, or to a replica picked by the replica balancer if server is nil, and
+// returns the global sequence number assigned by Scalog.
+func (c *Client) appendToServer(ctx context.Context, shardID int32, server *discovery.DataServer, csn int32, record string) (int32, error) {
+	ctx, conn, err := c.serverConn(ctx, shardID, server)
 	if err != nil {
-		return nil, err
+		return -1, err
 	}
-	var config config
-	err = yaml.Unmarshal(file, &config)
+	defer c.releaseConn(conn)
+	dataClient := data.NewDataClient(conn)
+	req := &data.AppendRequest{
+		Cid:    c.clientID,
+		Csn:    csn,
+		Record: record,
+	}
+	resp, err := dataClient.Append(ctx, req)
 	if err != nil {
-		return nil, err
+		return -1, err
+	}
+	err = c.checkView(resp.ViewID)
+	if err != nil {
+		return -1, err
 	}
-	return &config, nil
+	return resp.Gsn, nil
 }
 
-// subscribeToServer subscribes to a data server and sends CommittedRecords in
-// order to the subscribeChan
-func (c *Client) subscribeToServer(server *discovery.DataServer, gsn int32) error {
-	opts := []grpc.DialOption{grpc.WithInsecure()}
-	conn, err := grpc.Dial(getAddressOfServer(server), opts...)
+// subscribeToServer subscribes to a data server in a shard and sends
+// CommittedRecords in order to the subscribeChan. If the stream fails, the
+// client resubscribes from the next undelivered global sequence number until
+// the server ends the stream or the client is closed.
+func (c *Client) subscribeToServer(server *discovery.DataServer, shardID int32, gsn int32) {
+	backoff := minResubscribeBackoff
+	for {
+		err := newOpError("Subscribe", shardID, gsn, c.streamFromServer(server, shardID, gsn))
+		if err == nil {
+			c.logger.Info("Subscription stream ended", "shard", shardID, "server", server.ServerID)
+			return
+		}
+		c.logger.Warn("Subscription stream failed", "shard", shardID, "server", server.ServerID, "err", err)
+		select {
+		case <-c.done:
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxResubscribeBackoff {
+			backoff = maxResubscribeBackoff
+		}
+		c.subscribeMu.RLock()
+		gsn = c.nextGsn
+		c.subscribeMu.RUnlock()
+		c.logger.Info("Resubscribing to data server", "shard", shardID, "server", server.ServerID, "gsn", gsn)
+	}
+}
+
+// streamFromServer receives the records of a subscription to a data server in
+// a shard until the stream ends or fails.
+func (c *Client) streamFromServer(server *discovery.DataServer, shardID int32, gsn int32) error {
+	conn, err := c.dial(getAddressOfServer(server))
 	if err != nil {
 		return err
 	}
 	defer conn.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-c.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
 	dataClient := data.NewDataClient(conn)
 	req := &data.SubscribeRequest{SubscriptionGsn: gsn}
-	stream, err := dataClient.Subscribe(context.Background(), req)
+	stream, err := dataClient.Subscribe(ctx, req)
 	if err != nil {
 		return err
 	}
@@ -245,21 +508,33 @@ func (c *Client) subscribeToServer(server *discovery.DataServer, gsn int32) erro
 		if err != nil {
 			return err
 		}
+		c.shardIndex.add(in.Gsn, shardID)
 		c.subscribeMu.Lock()
-		c.committedRecords[in.Gsn] = CommittedRecord{
-			Gsn:    in.Gsn,
-			Record: in.Record,
+		if in.Gsn > c.nextGsn && len(c.committedRecords) == 0 {
+			c.logger.Debug("Waiting for gap in subscription", "nextGsn", c.nextGsn, "receivedGsn", in.Gsn)
+		}
+		if in.Gsn >= c.nextGsn {
+			c.committedRecords[in.Gsn] = CommittedRecord{
+				Gsn:    in.Gsn,
+				Record: in.Record,
+			}
+		}
+		if in.Gsn > c.maxReceivedGsn {
+			c.maxReceivedGsn = in.Gsn
 		}
 		if in.Gsn == c.nextGsn {
 			c.respond()
 		}
+		c.metrics.SetReorderBufferDepth(len(c.committedRecords))
+		if c.maxReceivedGsn >= c.nextGsn {
+			c.metrics.SetSubscriptionLag(int64(c.maxReceivedGsn - c.nextGsn + 1))
+		} else {
+			c.metrics.SetSubscriptionLag(0)
+		}
 		c.subscribeMu.Unlock()
-		if in.ViewID != c.viewID {
-			err := c.updateView()
-			if err != nil {
-				return err
-			}
-			c.viewID = in.ViewID
+		err = c.checkView(in.ViewID)
+		if err != nil {
+			return err
 		}
 	}
 }
@@ -281,8 +556,7 @@ func (c *Client) respond() {
 // trimFromServer deletes records before a global sequence number from a data
 // server.
 func (c *Client) trimFromServer(server *discovery.DataServer, gsn int32) error {
-	opts := []grpc.DialOption{grpc.WithInsecure()}
-	conn, err := grpc.Dial(getAddressOfServer(server), opts...)
+	conn, err := c.dial(getAddressOfServer(server))
 	if err != nil {
 		return err
 	}
@@ -290,73 +564,257 @@ func (c *Client) trimFromServer(server *discovery.DataServer, gsn int32) error {
 	dataClient := data.NewDataClient(conn)
 	req := &data.TrimRequest{Gsn: gsn}
 	resp, err := dataClient.Trim(context.Background(), req)
-	c.viewMu.Lock()
-	if resp.ViewID != c.viewID {
-		err = c.updateView()
-		if err != nil {
-			return err
+	if err != nil {
+		return err
+	}
+	return c.checkView(resp.ViewID)
+}
+
+// readFromShard reads a record with a global sequence number from a replica in
+// a shard. Reads are hedged if hedged reads are enabled, and otherwise fail
+// over to another replica if a data server fails.
+func (c *Client) readFromShard(ctx context.Context, shard *discovery.Shard, gsn int32) (string, error) {
+	start := time.Now()
+	var record string
+	var err error
+	if c.hedge != nil && len(shard.Servers) > 1 {
+		record, err = c.hedgedRead(ctx, shard, gsn)
+	} else {
+		record, err = c.readWithFailover(ctx, shard, gsn)
+	}
+	if err != nil && isRecordMissing(err) {
+		c.metrics.ObserveRead(shard.ShardID, time.Since(start), nil)
+	} else {
+		c.metrics.ObserveRead(shard.ShardID, time.Since(start), err)
+	}
+	return record, newOpError("Read", shard.ShardID, gsn, err)
+}
+
+// readWithFailover reads a record with a global sequence number from a random
+// replica in a shard, retrying on another replica if a data server fails.
+func (c *Client) readWithFailover(ctx context.Context, shard *discovery.Shard, gsn int32) (string, error) {
+	var err error = &OpError{Op: "Read", ShardID: shard.ShardID, Gsn: gsn, Kind: ErrUnavailable}
+	tried := make(map[*discovery.DataServer]bool)
+	for attempt := 0; ; attempt++ {
+		readCtx, server, ok := c.pickReplica(ctx, shard, tried, attempt == 0)
+		if !ok {
+			return "", err
+		}
+		var record string
+		record, err = c.readFromServer(readCtx, shard.ShardID, server, gsn)
+		if server = pickedServer(readCtx, shard, server); server != nil {
+			tried[server] = true
+			c.health.report(shard.ShardID, server.ServerID, err)
 		}
-		c.viewID = resp.ViewID
+		if err == nil || !isServerFailure(err) {
+			return record, err
+		}
+		c.logger.Warn("Retrying read on another replica", "shard", shard.ShardID, "server", serverIDOf(server), "gsn", gsn, "err", err)
 	}
-	c.viewMu.Unlock()
-	return err
 }
 
-// readFromServer reads a record with a global sequence number from a server.
-func (c *Client) readFromServer(server *discovery.DataServer, gsn int32) (string, error) {
-	opts := []grpc.DialOption{grpc.WithInsecure()}
-	conn, err := grpc.Dial(getAddressOfServer(server), opts...)
+// readFromServer reads a record with a global sequence number from a server of
+// a shard, or from a replica picked by the replica balancer if server is nil.
+func (c *Client) readFromServer(ctx context.Context, shardID int32, server *discovery.DataServer, gsn int32) (string, error) {
+	ctx, conn, err := c.serverConn(ctx, shardID, server)
 	if err != nil {
 		return "", err
 	}
-	defer conn.Close()
+	defer c.releaseConn(conn)
 	dataClient := data.NewDataClient(conn)
 	req := &data.ReadRequest{Gsn: gsn}
-	resp, err := dataClient.Read(context.Background(), req)
+	resp, err := dataClient.Read(ctx, req)
 	if err != nil {
 		return "", err
 	}
-	if resp.ViewID != c.viewID {
-		err := c.updateView()
-		if err != nil {
-			return "", err
-		}
-		c.viewID = resp.ViewID
+	err = c.checkView(resp.ViewID)
+	if err != nil {
+		return "", err
 	}
 	return resp.Record, nil
 }
 
+// checkView updates the client's view if a data server responded with a view
+// identifier different from the client's. The discovery service is queried by
+// one caller at a time without holding viewMu, so that requests keep using the
+// current view meanwhile, and is queried again for a view identifier it could
+// not be queried for only after a backoff.
+func (c *Client) checkView(viewID int32) error {
+	c.viewMu.RLock()
+	currentViewID, empty := c.viewID, len(c.view) == 0
+	c.viewMu.RUnlock()
+	if viewID == currentViewID || !c.beginViewUpdate(viewID) {
+		return nil
+	}
+	config := c.getConfig()
+	view, err := c.discover(config, c.getTLSConfig())
+	c.endViewUpdate(viewID, err)
+	if err != nil {
+		if empty {
+			return err
+		}
+		// Keep serving requests with the last known view while the discovery
+		// service is unreachable, and retry after the backoff
+		c.logger.Warn("Keeping last known view", "viewID", currentViewID, "err", err)
+		return nil
+	}
+	c.viewMu.Lock()
+	defer c.viewMu.Unlock()
+	if c.getConfig() != config {
+		// The configuration was reloaded along with the view meanwhile
+		return nil
+	}
+	c.logger.Info("View changed", "oldViewID", c.viewID, "viewID", viewID, "shards", len(view))
+	c.setView(view)
+	c.viewID = viewID
+	c.saveViewCache(config)
+	return nil
+}
+
+// beginViewUpdate returns whether the caller should query the discovery
+// service for a view identifier, which it should unless another caller is
+// querying it or the last query for the same identifier failed less than a
+// backoff ago.
+func (c *Client) beginViewUpdate(viewID int32) bool {
+	c.viewUpdateMu.Lock()
+	defer c.viewUpdateMu.Unlock()
+	if c.viewUpdating || (viewID == c.failedViewID && time.Now().Before(c.viewRetryAt)) {
+		return false
+	}
+	c.viewUpdating = true
+	return true
+}
+
+// endViewUpdate records the outcome of a query of the discovery service for a
+// view identifier, doubling the backoff after every consecutive failure.
+func (c *Client) endViewUpdate(viewID int32, err error) {
+	c.viewUpdateMu.Lock()
+	defer c.viewUpdateMu.Unlock()
+	c.viewUpdating = false
+	if err == nil {
+		c.viewRetryAt, c.viewBackoff = time.Time{}, 0
+		return
+	}
+	if c.viewBackoff == 0 {
+		c.viewBackoff = discoveryRetryBackoff
+	} else if c.viewBackoff < maxResubscribeBackoff {
+		c.viewBackoff *= 2
+	}
+	c.failedViewID = viewID
+	c.viewRetryAt = time.Now().Add(c.viewBackoff)
+}
+
 // updateView queries the discovery service and returns the live data servers
 // grouped by shard.
 func (c *Client) updateView() error {
-	opts := []grpc.DialOption{grpc.WithInsecure()}
-	conn, err := grpc.Dial(c.config.DiscoveryAddress.stats(), opts...)
+	config := c.getConfig()
+	view, err := c.discover(config, c.getTLSConfig())
 	if err != nil {
 		return err
 	}
-	defer conn.Close()
-	discoveryClient := discovery.NewDiscoveryClient(conn)
-	req := &discovery.DiscoverRequest{}
-	resp, err := discoveryClient.DiscoverServers(context.Background(), req)
+	c.setView(view)
+	return nil
+}
+
+// serverConn returns a connection on which requests reach a data server of a
+// shard, and the context with which to send them. Balanced clients share the
+// balanced connection to the shard and pin the requests to the server unless
+// it is nil, and other clients dial the server. The connection is released
+// with releaseConn.
+func (c *Client) serverConn(ctx context.Context, shardID int32, server *discovery.DataServer) (context.Context, *grpc.ClientConn, error) {
+	if c.balanced {
+		conn, err := c.shardConn(shardID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if server == nil {
+			return ctx, conn, nil
+		}
+		return withReplica(ctx, server.ServerID), conn, nil
+	}
+	// TODO: don't dial for every operation. Save the connection and reuse it
+	conn, err := c.dial(getAddressOfServer(server))
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+	return ctx, conn, nil
+}
+
+// releaseConn releases a connection returned by serverConn, closing it unless
+// it is a shared balanced connection.
+func (c *Client) releaseConn(conn *grpc.ClientConn) {
+	if !c.balanced {
+		conn.Close()
+	}
+}
+
+// dial creates a client connection to an address with the client's transport
+// security settings.
+func (c *Client) dial(address string) (*grpc.ClientConn, error) {
+	return c.dialWith(address, c.getConfig(), c.getTLSConfig())
+}
+
+// dialWith creates a client connection to an address with a configuration and
+// transport security settings.
+func (c *Client) dialWith(address string, config *config, tlsConfig *tls.Config) (*grpc.ClientConn, error) {
+	conn, err := grpc.Dial(address, c.dialOptions(config, tlsConfig)...)
+	if err != nil {
+		c.logger.Error("Failed to dial", "address", address, "err", err)
+	}
+	return conn, err
+}
+
+// dialOptions returns the options with which the client dials Scalog with a
+// configuration and transport security settings.
+func (c *Client) dialOptions(config *config, tlsConfig *tls.Config) []grpc.DialOption {
+	opts := []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(c.unaryInterceptors...),
+		grpc.WithChainUnaryInterceptor(authUnaryInterceptor),
+		grpc.WithChainStreamInterceptor(c.streamInterceptors...),
+		grpc.WithChainStreamInterceptor(authStreamInterceptor),
+	}
+	if config.RequestTimeout > 0 {
+		opts = append(opts, grpc.WithChainUnaryInterceptor(timeoutUnaryInterceptor(config.RequestTimeout)))
+	}
+	if tlsConfig == nil {
+		opts = append(opts, grpc.WithInsecure())
+	} else {
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	}
+	if c.tokenSource != nil {
+		opts = append(opts, grpc.WithPerRPCCredentials(tokenCredentials{source: c.tokenSource, insecure: c.insecureTokens}))
+	}
+	return opts
+}
+
+// getView returns the client's current view. Views are replaced rather than
+// modified, so the view returned may be used without holding viewMu.
+func (c *Client) getView() []*discovery.Shard {
+	c.viewMu.RLock()
+	defer c.viewMu.RUnlock()
+	return c.view
+}
+
+// getShard returns the shard with an identifier in the client's view, or nil if
+// no such shard exists.
+func (c *Client) getShard(shardID int32) *discovery.Shard {
+	for _, shard := range c.getView() {
+		if shard.ShardID == shardID {
+			return shard
+		}
 	}
-	c.view = resp.Shards
 	return nil
 }
 
-// getRandomServerInShard returns a random server in a shard.
-func getRandomServerInShard(shard *discovery.Shard) *discovery.DataServer {
-	seed := rand.NewSource(time.Now().UnixNano())
-	return shard.Servers[rand.New(seed).Intn(len(shard.Servers))]
+// serverIDOf returns the identifier of a server, or -1 if server is nil.
+func serverIDOf(server *discovery.DataServer) int32 {
+	if server == nil {
+		return -1
+	}
+	return server.ServerID
 }
 
 // getAddressOfServer returns the address of a server as a string.
 func getAddressOfServer(server *discovery.DataServer) string {
-	return fmt.Sprintf("%s:%d", server.Ip, server.Port)
-}
-
-// stats returns an address as a string
-func (a address) stats() string {
-	return fmt.Sprintf("%s:%d", a.IP, a.Port)
+	return net.JoinHostPort(server.Ip, strconv.Itoa(int(server.Port)))
 }
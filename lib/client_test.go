@@ -1,21 +1,51 @@
+//go:build go1.13
+// +build go1.13
+
 package lib
 
 import (
+	"errors"
+	"fmt"
 	"testing"
+	"time"
+
+	"github.com/scalog/scalog-client/scalogtest"
 )
 
-func TestNewClient(t *testing.T) {
-	_, err := NewClient()
+// newTestCluster starts an in-process cluster with two shards of two replicas
+// each.
+func newTestCluster(t *testing.T) *scalogtest.Cluster {
+	cluster, err := scalogtest.NewCluster(2, 2)
 	if err != nil {
-		t.Fatalf(err.Error())
+		t.Fatal(err)
 	}
+	return cluster
 }
 
-func TestAppend(t *testing.T) {
-	client, err := NewClient()
+// newTestClient returns a client of an in-process cluster.
+func newTestClient(t *testing.T, cluster *scalogtest.Cluster, opts ...Option) *Client {
+	client, err := NewClient(append([]Option{WithDiscoveryAddress(cluster.DiscoveryAddress())}, opts...)...)
 	if err != nil {
-		t.Fatalf(err.Error())
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestNewClient(t *testing.T) {
+	cluster := newTestCluster(t)
+	defer cluster.Close()
+	client := newTestClient(t, cluster)
+	defer client.Close()
+	if len(client.view) != 2 {
+		t.Fatalf("Expected: %d, Actual: %d", 2, len(client.view))
 	}
+}
+
+func TestAppend(t *testing.T) {
+	cluster := newTestCluster(t)
+	defer cluster.Close()
+	client := newTestClient(t, cluster)
+	defer client.Close()
 	gsn, err := client.Append("Hello, World!")
 	if err != nil {
 		t.Fatalf(err.Error())
@@ -23,13 +53,16 @@ func TestAppend(t *testing.T) {
 	if gsn < 0 {
 		t.Fatalf("Record assigned invalid global sequence number %d", gsn)
 	}
+	if record := cluster.Records()[gsn]; record != "Hello, World!" {
+		t.Fatalf("Expected: %s, Actual: %s", "Hello, World!", record)
+	}
 }
 
 func TestSubscribe(t *testing.T) {
-	client, err := NewClient()
-	if err != nil {
-		t.Fatalf(err.Error())
-	}
+	cluster := newTestCluster(t)
+	defer cluster.Close()
+	client := newTestClient(t, cluster)
+	defer client.Close()
 	gsn, err := client.Append("Hello, World!")
 	if err != nil {
 		t.Errorf(err.Error())
@@ -44,11 +77,40 @@ func TestSubscribe(t *testing.T) {
 	}
 }
 
-func TestReadRecord(t *testing.T) {
-	client, err := NewClient()
+func TestSubscribeOrder(t *testing.T) {
+	cluster := newTestCluster(t)
+	defer cluster.Close()
+	client := newTestClient(t, cluster)
+	defer client.Close()
+	subscribeChan, err := client.Subscribe(1)
 	if err != nil {
-		t.Fatalf(err.Error())
+		t.Fatal(err)
+	}
+	for i := 0; i < 16; i++ {
+		if _, err := client.Append(fmt.Sprintf("Record %d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 16; i++ {
+		select {
+		case resp := <-subscribeChan:
+			if resp.Gsn != int32(i+1) {
+				t.Fatalf("Expected: %d, Actual: %d", i+1, resp.Gsn)
+			}
+			if expected := fmt.Sprintf("Record %d", i); resp.Record != expected {
+				t.Fatalf("Expected: %s, Actual: %s", expected, resp.Record)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for record %d", i+1)
+		}
 	}
+}
+
+func TestReadRecord(t *testing.T) {
+	cluster := newTestCluster(t)
+	defer cluster.Close()
+	client := newTestClient(t, cluster)
+	defer client.Close()
 	expected := "Hello, World!"
 	gsn, shardID, err := client.AppendToShard(expected)
 	if err != nil {
@@ -61,13 +123,38 @@ func TestReadRecord(t *testing.T) {
 	if expected != actual {
 		t.Fatalf("Expected: %s, Actual: %s", expected, actual)
 	}
+	_, err = client.ReadRecord(gsn+1, shardID)
+	if !errors.Is(err, ErrNotCommitted) {
+		t.Fatalf("Expected: %v, Actual: %v", ErrNotCommitted, err)
+	}
 }
 
-func TestTrim(t *testing.T) {
-	client, err := NewClient()
+func TestRead(t *testing.T) {
+	cluster := newTestCluster(t)
+	defer cluster.Close()
+	client := newTestClient(t, cluster)
+	defer client.Close()
+	expected := "Hello, World!"
+	gsn, err := client.Append(expected)
 	if err != nil {
-		t.Fatalf(err.Error())
+		t.Fatal(err)
+	}
+	other := newTestClient(t, cluster)
+	defer other.Close()
+	actual, err := other.Read(gsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected != actual {
+		t.Fatalf("Expected: %s, Actual: %s", expected, actual)
 	}
+}
+
+func TestTrim(t *testing.T) {
+	cluster := newTestCluster(t)
+	defer cluster.Close()
+	client := newTestClient(t, cluster)
+	defer client.Close()
 	expected := "Hello, World!"
 	gsn, shardID, err := client.AppendToShard(expected)
 	if err != nil {
@@ -84,4 +171,174 @@ func TestTrim(t *testing.T) {
 	if err != nil {
 		t.Fatalf(err.Error())
 	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, in := cluster.Records()[gsn]; !in {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Record %d not trimmed", gsn)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	_, err = client.ReadRecord(gsn, shardID)
+	if !errors.Is(err, ErrRecordTrimmed) {
+		t.Fatalf("Expected: %v, Actual: %v", ErrRecordTrimmed, err)
+	}
+}
+
+func TestReadRange(t *testing.T) {
+	cluster := newTestCluster(t)
+	defer cluster.Close()
+	client := newTestClient(t, cluster)
+	defer client.Close()
+	expected := make(map[int32]string)
+	from := int32(-1)
+	to := int32(-1)
+	for i := 0; i < 4; i++ {
+		record := fmt.Sprintf("Record %d", i)
+		gsn, err := client.Append(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if from < 0 {
+			from = gsn
+		}
+		to = gsn + 1
+		expected[gsn] = record
+	}
+	it := client.ReadRange(from, to)
+	defer it.Close()
+	prevGsn := from - 1
+	for it.Next() {
+		committedRecord := it.Record()
+		if committedRecord.Gsn != prevGsn+1 {
+			t.Fatalf("Expected: %d, Actual: %d", prevGsn+1, committedRecord.Gsn)
+		}
+		prevGsn = committedRecord.Gsn
+		if record, in := expected[committedRecord.Gsn]; in && record != committedRecord.Record {
+			t.Fatalf("Expected: %s, Actual: %s", record, committedRecord.Record)
+		}
+	}
+	if it.Err() != nil {
+		t.Fatal(it.Err())
+	}
+	if prevGsn != to-1 {
+		t.Fatalf("Expected: %d, Actual: %d", to-1, prevGsn)
+	}
+}
+
+func TestReadRangeTrimmed(t *testing.T) {
+	cluster := newTestCluster(t)
+	defer cluster.Close()
+	client := newTestClient(t, cluster)
+	defer client.Close()
+	var gsn int32
+	for i := 0; i < 4; i++ {
+		var err error
+		gsn, err = client.Append(fmt.Sprintf("Record %d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := client.Trim(gsn); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, in := cluster.Records()[gsn-1]; !in {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Record %d not trimmed", gsn-1)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	it := client.ReadRange(1, gsn+1)
+	defer it.Close()
+	if it.Next() {
+		t.Fatalf("Expected no records, Actual: %v", it.Record())
+	}
+	if !errors.Is(it.Err(), ErrRecordTrimmed) {
+		t.Fatalf("Expected: %v, Actual: %v", ErrRecordTrimmed, it.Err())
+	}
+}
+
+func TestAppendUnavailableReplica(t *testing.T) {
+	cluster := newTestCluster(t)
+	defer cluster.Close()
+	client := newTestClient(t, cluster)
+	defer client.Close()
+	for _, server := range cluster.Servers() {
+		if server.ServerID()%2 == 0 {
+			server.SetFault(scalogtest.Fault{Unavailable: true})
+		}
+	}
+	for i := 0; i < 4; i++ {
+		if _, err := client.Append(fmt.Sprintf("Record %d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(cluster.Records()) != 4 {
+		t.Fatalf("Expected: %d, Actual: %d", 4, len(cluster.Records()))
+	}
+}
+
+func TestSubscribeResetStreams(t *testing.T) {
+	cluster := newTestCluster(t)
+	defer cluster.Close()
+	client := newTestClient(t, cluster)
+	defer client.Close()
+	subscribeChan, err := client.Subscribe(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 8; i++ {
+		if i == 4 {
+			for _, server := range cluster.Servers() {
+				server.ResetStreams()
+			}
+		}
+		if _, err := client.Append(fmt.Sprintf("Record %d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 8; i++ {
+		select {
+		case resp := <-subscribeChan:
+			if resp.Gsn != int32(i+1) {
+				t.Fatalf("Expected: %d, Actual: %d", i+1, resp.Gsn)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for record %d", i+1)
+		}
+	}
+}
+
+func TestViewChange(t *testing.T) {
+	cluster := newTestCluster(t)
+	defer cluster.Close()
+	client := newTestClient(t, cluster)
+	defer client.Close()
+	server, err := cluster.Server(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.SetFault(scalogtest.Fault{Hidden: true})
+	cluster.ChangeView(false)
+	if _, err := client.Append("Hello, World!"); err != nil {
+		t.Fatal(err)
+	}
+	client.viewMu.Lock()
+	defer client.viewMu.Unlock()
+	if client.viewID != cluster.ViewID() {
+		t.Fatalf("Expected: %d, Actual: %d", cluster.ViewID(), client.viewID)
+	}
+	servers := 0
+	for _, shard := range client.view {
+		servers += len(shard.Servers)
+	}
+	if servers != 3 {
+		t.Fatalf("Expected: %d, Actual: %d", 3, servers)
+	}
 }
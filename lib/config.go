@@ -0,0 +1,293 @@
+package lib
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// defaultConfigFile is the path of the configuration file read by
+	// NewClient.
+	defaultConfigFile = "./config.yaml"
+	// envPrefix is the prefix of the environment variables overriding
+	// settings in the configuration file.
+	envPrefix = "SCALOG"
+)
+
+// configKeys are the settings that may be overridden by environment variables,
+// such as SCALOG_DISCOVERY_ADDRESS_IP for discovery-address.ip.
+var configKeys = []string{
+	"context",
+	"discovery-address.ip",
+	"discovery-address.port",
+	"discovery-endpoints",
+	"tls.ca-file",
+	"tls.cert-file",
+	"tls.key-file",
+	"tls.server-name",
+	"tls.insecure-skip-verify",
+	"request-timeout",
+	"shard-policy",
+	"view-cache",
+	"view-cache-max-staleness",
+	"zone",
+}
+
+// address represents an IP address and port number.
+type address struct {
+	IP   string `yaml:"ip"`
+	Port int32  `yaml:"port"`
+}
+
+// config contains the meta-data specified in config.yaml, environment
+// variables and flags.
+type config struct {
+	DiscoveryAddress address `yaml:"discovery-address,omitempty"`
+	// Additional discovery endpoints tried in order of health when the
+	// discovery address is unreachable, each host:port or srv://name
+	DiscoveryEndpoints []string `yaml:"discovery-endpoints,omitempty"`
+	// Rules translating the addresses of data servers advertised by the
+	// discovery service, the first matching rule applying
+	AddressRules []AddressRule `yaml:"address-rules,omitempty"`
+	// Shards and data servers used instead of the discovery service if no
+	// discovery endpoint is set, or while every endpoint is unreachable
+	StaticView []StaticShard `yaml:"static-view,omitempty"`
+	// TLS settings for connections to Scalog, or nil if connections are
+	// insecure
+	TLS *tlsConfig `yaml:"tls,omitempty"`
+	// Deadline of each unary request to Scalog, or 0 if requests have no
+	// deadline
+	RequestTimeout time.Duration `yaml:"request-timeout,omitempty"`
+	// Name of the shard policy, random or hash, or empty for the policy set
+	// with SetShardPolicy
+	ShardPolicy string `yaml:"shard-policy,omitempty"`
+	// Path of the file caching the view, or empty if the view is not cached
+	ViewCache string `yaml:"view-cache,omitempty"`
+	// Age after which the cached view is ignored, or 0 if it never is
+	ViewCacheMaxStaleness time.Duration `yaml:"view-cache-max-staleness,omitempty"`
+	// Zone of the client, or empty if replicas are picked regardless of zone
+	Zone string `yaml:"zone,omitempty"`
+	// Localities assigning data servers to zones, the first matching one
+	// applying
+	Localities []Locality `yaml:"localities,omitempty"`
+}
+
+// tlsConfig contains the TLS settings specified in config.yaml.
+type tlsConfig struct {
+	// Path of the PEM-encoded CA bundle used to verify servers, or empty to
+	// use the system roots
+	CAFile string `yaml:"ca-file"`
+	// Paths of the PEM-encoded client certificate and key presented to
+	// servers requiring mutual TLS, or empty if none
+	CertFile string `yaml:"cert-file"`
+	KeyFile  string `yaml:"key-file"`
+	// Name used to verify server certificates instead of the dialed host
+	ServerName string `yaml:"server-name"`
+	// Whether server certificates are accepted without verification. This
+	// must only be used in test environments
+	InsecureSkipVerify bool `yaml:"insecure-skip-verify"`
+}
+
+// WithDiscoveryAddress sets the IP and port of the Scalog discovery service,
+// overriding the address in config.yaml and environment variables.
+func WithDiscoveryAddress(ip string, port int32) Option {
+	return func(c *Client) error {
+		c.config.DiscoveryAddress = address{IP: ip, Port: port}
+		return nil
+	}
+}
+
+// WithTLSConfig secures connections to the discovery service and data servers
+// with TLS, overriding the TLS settings in config.yaml.
+func WithTLSConfig(tlsConfig *tls.Config) Option {
+	return func(c *Client) error {
+		c.tlsConfig = tlsConfig
+		return nil
+	}
+}
+
+// WithViper configures the client with the settings resolved by a viper
+// instance set up with ConfigureViper, replacing the settings read from
+// config.yaml and environment variables. Options given before WithViper are
+// overridden by it.
+func WithViper(v *viper.Viper) Option {
+	return func(c *Client) error {
+		config, err := loadConfig(v)
+		if err != nil {
+			return err
+		}
+		c.config = config
+		return nil
+	}
+}
+
+// ConfigureViper sets up a viper instance to resolve the client's settings from,
+// in order of precedence, flags bound to it, SCALOG_* environment variables,
+// the selected context of the configuration file and the top level of the
+// configuration file. The configuration file is read from path, or from
+// ./config.yaml if path is empty and the file exists.
+//
+// A configuration file may define named contexts, each holding settings for a
+// cluster. The context is selected by the context setting, which flags and
+// SCALOG_CONTEXT may set, or else by current-context in the file. Context names
+// are case-insensitive:
+//
+//	current-context: local
+//	contexts:
+//	  local:
+//	    discovery-address:
+//	      ip: "127.0.0.1"
+//	      port: 8000
+func ConfigureViper(v *viper.Viper, path string) error {
+	v.SetEnvPrefix(envPrefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_", "-", "_"))
+	for _, key := range configKeys {
+		err := v.BindEnv(key)
+		if err != nil {
+			return err
+		}
+	}
+	if path == "" {
+		if _, err := os.Stat(defaultConfigFile); err != nil {
+			return nil
+		}
+		path = defaultConfigFile
+	}
+	v.SetConfigFile(path)
+	v.SetConfigType("yaml")
+	err := v.ReadInConfig()
+	if err != nil {
+		return err
+	}
+	name := CurrentContext(v)
+	if name == "" {
+		return nil
+	}
+	contexts := v.GetStringMap("contexts")
+	settings, in := contexts[name]
+	if !in {
+		return fmt.Errorf("Context %s not found in %s", name, path)
+	}
+	settingsMap, ok := settings.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("Context %s in %s is not a map", name, path)
+	}
+	return v.MergeConfigMap(settingsMap)
+}
+
+// CurrentContext returns the name of the context selected in a viper instance,
+// or an empty string if none is selected. Viper lowercases the keys of maps, so
+// context names are case-insensitive and the name is returned in lower case.
+func CurrentContext(v *viper.Viper) string {
+	if name := v.GetString("context"); name != "" {
+		return strings.ToLower(name)
+	}
+	return strings.ToLower(v.GetString("current-context"))
+}
+
+// MarshalConfig returns the client's settings resolved by a viper instance in
+// YAML.
+func MarshalConfig(v *viper.Viper) ([]byte, error) {
+	config, err := loadConfig(v)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(config)
+}
+
+// parseConfig initializes and returns an instance of config with the meta-data
+// specified in the configuration file at path and environment variables. If
+// path is empty, ./config.yaml is read if it exists, so that the client may be
+// configured with options instead.
+func parseConfig(path string) (*config, error) {
+	v := viper.New()
+	err := ConfigureViper(v, path)
+	if err != nil {
+		return nil, err
+	}
+	return loadConfig(v)
+}
+
+// loadConfig returns an instance of config with the settings resolved by a
+// viper instance.
+func loadConfig(v *viper.Viper) (*config, error) {
+	var config config
+	err := v.Unmarshal(&config, func(dc *mapstructure.DecoderConfig) {
+		dc.TagName = "yaml"
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// validate returns an error if the config is missing required meta-data.
+func (c *config) validate() error {
+	if len(c.discoveryEndpoints()) == 0 && len(c.StaticView) == 0 {
+		return fmt.Errorf("Missing discovery address; set discovery-address, discovery-endpoints or static-view in %s or %s_DISCOVERY_ADDRESS_IP", defaultConfigFile, envPrefix)
+	}
+	if err := validateStaticView(c.StaticView); err != nil {
+		return err
+	}
+	if err := validateLocalities(c.Localities); err != nil {
+		return err
+	}
+	if c.TLS != nil && (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		return fmt.Errorf("TLS cert-file and key-file must be specified together")
+	}
+	if _, err := newAddressMapper(c.AddressRules); err != nil {
+		return err
+	}
+	if c.RequestTimeout < 0 {
+		return fmt.Errorf("Request timeout must not be negative")
+	}
+	if c.ViewCacheMaxStaleness < 0 {
+		return fmt.Errorf("View cache max staleness must not be negative")
+	}
+	if _, in := shardPolicies[c.ShardPolicy]; c.ShardPolicy != "" && !in {
+		return fmt.Errorf("Unknown shard policy %s", c.ShardPolicy)
+	}
+	return nil
+}
+
+// build returns the crypto/tls configuration described by the TLS settings.
+func (t *tlsConfig) build() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+	if t.CAFile != "" {
+		ca, err := ioutil.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("No certificates found in CA file %s", t.CAFile)
+		}
+	}
+	if t.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// stats returns an address as a string
+func (a address) stats() string {
+	return net.JoinHostPort(a.IP, strconv.Itoa(int(a.Port)))
+}
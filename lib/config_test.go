@@ -0,0 +1,220 @@
+package lib
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	discovery "github.com/scalog/scalog/discovery/rpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// staticDiscoveryServer is a discovery service that always returns one shard.
+type staticDiscoveryServer struct{}
+
+func (staticDiscoveryServer) DiscoverServers(ctx context.Context, req *discovery.DiscoverRequest) (*discovery.DiscoverResponse, error) {
+	shard := &discovery.Shard{
+		ShardID: 0,
+		Servers: []*discovery.DataServer{{ServerID: 0, Ip: "127.0.0.1", Port: 1}},
+	}
+	return &discovery.DiscoverResponse{Shards: []*discovery.Shard{shard}}, nil
+}
+
+// writeCertificate creates a certificate signed by parent, or self-signed if
+// parent is nil, and writes it and its key to PEM files in dir.
+func writeCertificate(t *testing.T, dir string, name string, template *x509.Certificate, parent *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parentCert, parentKey := template, interface{}(key)
+	if parent != nil {
+		parentCert = parent.Leaf
+		parentKey = parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	err = ioutil.WriteFile(filepath.Join(dir, name+".crt"), certPem, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, name+".key"), keyPem, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := tls.X509KeyPair(certPem, keyPem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert.Leaf, err = x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	notAfter := time.Now().Add(time.Hour)
+	ca := writeCertificate(t, dir, "ca", &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "scalog-ca"},
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}, nil)
+	serverCert := writeCertificate(t, dir, "server", &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "discovery.scalog"},
+		DNSNames:     []string{"discovery.scalog"},
+		NotAfter:     notAfter,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, &ca)
+	writeCertificate(t, dir, "client", &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "scalog-client"},
+		NotAfter:     notAfter,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, &ca)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.Leaf)
+	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})))
+	discovery.RegisterDiscoveryServer(server, staticDiscoveryServer{})
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(lis)
+	defer server.Stop()
+	port := int32(lis.Addr().(*net.TCPAddr).Port)
+
+	configFile := filepath.Join(dir, "config.yaml")
+	err = ioutil.WriteFile(configFile, []byte(fmt.Sprintf(`discovery-address:
+  ip: "127.0.0.1"
+  port: %d
+tls:
+  ca-file: %s
+  cert-file: %s
+  key-file: %s
+  server-name: discovery.scalog
+`, port, filepath.Join(dir, "ca.crt"), filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key"))), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := parseConfig(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig, err := config.TLS.build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(WithDiscoveryAddress("127.0.0.1", port), WithTLSConfig(tlsConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if len(client.view) != 1 {
+		t.Fatalf("Expected: %d, Actual: %d", 1, len(client.view))
+	}
+
+	tlsConfig.Certificates = nil
+	_, err = NewClient(WithDiscoveryAddress("127.0.0.1", port), WithTLSConfig(tlsConfig))
+	if err == nil {
+		t.Fatalf("Expected connection without client certificate to be rejected")
+	}
+}
+
+func TestConfigContexts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configFile := filepath.Join(dir, "config.yaml")
+	err = ioutil.WriteFile(configFile, []byte(`discovery-address:
+  ip: "10.0.0.1"
+  port: 8000
+current-context: staging
+contexts:
+  staging:
+    discovery-address:
+      ip: "10.0.0.2"
+  Production:
+    discovery-address:
+      ip: "10.0.0.3"
+      port: 9000
+    tls:
+      server-name: scalog.production
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := parseConfig(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := address{IP: "10.0.0.2", Port: 8000}
+	if config.DiscoveryAddress != expected {
+		t.Fatalf("Expected: %v, Actual: %v", expected, config.DiscoveryAddress)
+	}
+	if config.TLS != nil {
+		t.Fatalf("Expected: %v, Actual: %v", nil, config.TLS)
+	}
+
+	// Context names are case-insensitive
+	os.Setenv("SCALOG_CONTEXT", "PRODUCTION")
+	defer os.Unsetenv("SCALOG_CONTEXT")
+	os.Setenv("SCALOG_DISCOVERY_ADDRESS_PORT", "9001")
+	defer os.Unsetenv("SCALOG_DISCOVERY_ADDRESS_PORT")
+	config, err = parseConfig(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = address{IP: "10.0.0.3", Port: 9001}
+	if config.DiscoveryAddress != expected {
+		t.Fatalf("Expected: %v, Actual: %v", expected, config.DiscoveryAddress)
+	}
+	if config.TLS == nil || config.TLS.ServerName != "scalog.production" {
+		t.Fatalf("Expected: %s, Actual: %v", "scalog.production", config.TLS)
+	}
+
+	os.Setenv("SCALOG_CONTEXT", "development")
+	_, err = parseConfig(configFile)
+	if err == nil {
+		t.Fatalf("Expected error for unknown context")
+	}
+}
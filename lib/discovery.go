@@ -0,0 +1,254 @@
+package lib
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	discovery "github.com/scalog/scalog/discovery/rpc"
+)
+
+const (
+	// defaultDiscoveryTimeout is the deadline of a query to a discovery
+	// endpoint if no request timeout is configured.
+	defaultDiscoveryTimeout = 5 * time.Second
+	// defaultDiscoveryAttempts is the number of times NewClient tries every
+	// discovery endpoint before failing.
+	defaultDiscoveryAttempts = 3
+	// discoveryRetryBackoff is the delay before NewClient tries the discovery
+	// endpoints again, doubled after every attempt.
+	discoveryRetryBackoff = 200 * time.Millisecond
+	// discoveryCooldown is the time during which a discovery endpoint that
+	// failed is tried only after the others.
+	discoveryCooldown = 30 * time.Second
+	// srvPrefix marks a discovery endpoint resolved with a DNS SRV lookup.
+	srvPrefix = "srv://"
+)
+
+// discoveryTracker orders discovery endpoints by health, preferring the
+// endpoint that last answered and avoiding endpoints that failed recently.
+type discoveryTracker struct {
+	// Address of the endpoint that last answered, or empty if none
+	preferred string
+	// Map from address to the time the endpoint last failed
+	failedAt map[string]time.Time
+	// Mutex for accessing preferred and failedAt
+	mu sync.Mutex
+}
+
+// WithDiscoveryEndpoints adds discovery endpoints tried after the discovery
+// address. Each endpoint is either host:port, where host may be a DNS name
+// resolving to several addresses, or srv://name for a DNS SRV lookup of name.
+func WithDiscoveryEndpoints(endpoints ...string) Option {
+	return func(c *Client) error {
+		c.config.DiscoveryEndpoints = append(c.config.DiscoveryEndpoints, endpoints...)
+		return nil
+	}
+}
+
+// newDiscoveryTracker returns a new instance of discoveryTracker.
+func newDiscoveryTracker() *discoveryTracker {
+	return &discoveryTracker{failedAt: make(map[string]time.Time)}
+}
+
+// order returns addresses with the preferred address first, followed by the
+// addresses that have not failed recently and then by the others, keeping the
+// given order otherwise.
+func (t *discoveryTracker) order(addresses []string) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var preferred, healthy, failed []string
+	for _, address := range addresses {
+		switch failedAt, in := t.failedAt[address]; {
+		case address == t.preferred:
+			preferred = append(preferred, address)
+		case in && time.Since(failedAt) < discoveryCooldown:
+			failed = append(failed, address)
+		default:
+			healthy = append(healthy, address)
+		}
+	}
+	return append(append(preferred, healthy...), failed...)
+}
+
+// report records the outcome of a query to an endpoint.
+func (t *discoveryTracker) report(address string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err != nil {
+		t.failedAt[address] = time.Now()
+		if t.preferred == address {
+			t.preferred = ""
+		}
+		return
+	}
+	delete(t.failedAt, address)
+	t.preferred = address
+}
+
+// discoveryEndpoints returns the configured discovery endpoints, starting with
+// the discovery address if it is set.
+func (c *config) discoveryEndpoints() []string {
+	var endpoints []string
+	if c.DiscoveryAddress.IP != "" {
+		endpoints = append(endpoints, c.DiscoveryAddress.stats())
+	}
+	return append(endpoints, c.DiscoveryEndpoints...)
+}
+
+// resolveEndpoints resolves discovery endpoints to the addresses they refer to,
+// without duplicates. Endpoints that fail to resolve are skipped, and the first
+// such error is returned if no endpoint resolves.
+func resolveEndpoints(ctx context.Context, endpoints []string) ([]string, error) {
+	var addresses []string
+	var firstErr error
+	seen := make(map[string]bool)
+	for _, endpoint := range endpoints {
+		resolved, err := resolveEndpoint(ctx, endpoint)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		for _, address := range resolved {
+			if !seen[address] {
+				seen[address] = true
+				addresses = append(addresses, address)
+			}
+		}
+	}
+	if len(addresses) == 0 && firstErr != nil {
+		return nil, firstErr
+	}
+	return addresses, nil
+}
+
+// resolveEndpoint resolves a discovery endpoint to the addresses it refers to.
+func resolveEndpoint(ctx context.Context, endpoint string) ([]string, error) {
+	if strings.HasPrefix(endpoint, srvPrefix) {
+		_, records, err := net.DefaultResolver.LookupSRV(ctx, "", "", strings.TrimPrefix(endpoint, srvPrefix))
+		if err != nil {
+			return nil, err
+		}
+		addresses := make([]string, len(records))
+		for i, record := range records {
+			target := strings.TrimSuffix(record.Target, ".")
+			addresses[i] = net.JoinHostPort(target, strconv.Itoa(int(record.Port)))
+		}
+		return addresses, nil
+	}
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid discovery endpoint %s: %v", endpoint, err)
+	}
+	if net.ParseIP(host) != nil {
+		return []string{endpoint}, nil
+	}
+	ips, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	addresses := make([]string, len(ips))
+	for i, ip := range ips {
+		addresses[i] = net.JoinHostPort(ip, port)
+	}
+	return addresses, nil
+}
+
+// discover returns the live data servers grouped by shard, with their
+// addresses translated by the address rules of a configuration. The view is
+// queried from the discovery endpoints of the configuration, or is its static
+// view if it has no endpoints or every endpoint is unreachable.
+func (c *Client) discover(config *config, tlsConfig *tls.Config) ([]*discovery.Shard, error) {
+	mapper, err := newAddressMapper(config.AddressRules)
+	if err != nil {
+		return nil, err
+	}
+	if len(config.discoveryEndpoints()) == 0 {
+		return mapper.translateView(staticView(config.StaticView)), nil
+	}
+	view, err := c.queryDiscovery(config, tlsConfig)
+	if err != nil {
+		if len(config.StaticView) == 0 {
+			return nil, err
+		}
+		c.logger.Warn("Falling back to static view", "err", err)
+		view = staticView(config.StaticView)
+	}
+	return mapper.translateView(view), nil
+}
+
+// queryDiscovery queries the discovery endpoints of a configuration in order
+// of health until one answers, and returns the live data servers grouped by
+// shard.
+func (c *Client) queryDiscovery(config *config, tlsConfig *tls.Config) ([]*discovery.Shard, error) {
+	timeout := config.RequestTimeout
+	if timeout == 0 {
+		timeout = defaultDiscoveryTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	addresses, err := resolveEndpoints(ctx, config.discoveryEndpoints())
+	cancel()
+	if err != nil {
+		err = &OpError{Op: "DiscoverServers", ShardID: -1, Gsn: -1, Kind: ErrUnavailable, Err: err}
+		c.metrics.ObserveViewRefresh(err)
+		c.logger.Warn("Failed to resolve discovery endpoints", "err", err)
+		return nil, err
+	}
+	err = &OpError{Op: "DiscoverServers", ShardID: -1, Gsn: -1, Kind: ErrUnavailable}
+	for _, address := range c.discoveryHealth.order(addresses) {
+		var view []*discovery.Shard
+		view, err = c.discoverFrom(address, config, tlsConfig, timeout)
+		c.discoveryHealth.report(address, err)
+		if err == nil {
+			c.metrics.ObserveViewRefresh(nil)
+			return view, nil
+		}
+		err = newOpError("DiscoverServers", -1, -1, err)
+		c.logger.Warn("Failed to query discovery service", "address", address, "err", err)
+	}
+	c.metrics.ObserveViewRefresh(err)
+	return nil, err
+}
+
+// discoverFrom queries the discovery service at an address and returns the
+// live data servers grouped by shard.
+func (c *Client) discoverFrom(address string, config *config, tlsConfig *tls.Config, timeout time.Duration) ([]*discovery.Shard, error) {
+	conn, err := c.dialWith(address, config, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	discoveryClient := discovery.NewDiscoveryClient(conn)
+	resp, err := discoveryClient.DiscoverServers(ctx, &discovery.DiscoverRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Shards, nil
+}
+
+// updateViewWithRetry updates the client's view, trying every discovery
+// endpoint a number of times with exponential backoff.
+func (c *Client) updateViewWithRetry(attempts int) error {
+	backoff := discoveryRetryBackoff
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		err = c.updateView()
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
@@ -0,0 +1,136 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// deadAddress returns an address with no server behind it.
+func deadAddress(t *testing.T) (string, int32) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	address := listener.Addr().(*net.TCPAddr)
+	return address.IP.String(), int32(address.Port)
+}
+
+func TestDiscoveryTrackerOrder(t *testing.T) {
+	tracker := newDiscoveryTracker()
+	addresses := []string{"10.0.0.1:8000", "10.0.0.2:8000", "10.0.0.3:8000"}
+	tracker.report("10.0.0.1:8000", fmt.Errorf("unreachable"))
+	tracker.report("10.0.0.3:8000", nil)
+	expected := []string{"10.0.0.3:8000", "10.0.0.2:8000", "10.0.0.1:8000"}
+	if order := tracker.order(addresses); !reflect.DeepEqual(order, expected) {
+		t.Fatalf("Expected: %v, Actual: %v", expected, order)
+	}
+}
+
+func TestDiscoveryFailover(t *testing.T) {
+	cluster := newTestCluster(t)
+	defer cluster.Close()
+	_, port := cluster.DiscoveryAddress()
+	client, err := NewClient(
+		WithDiscoveryAddress(deadAddress(t)),
+		WithDiscoveryEndpoints(fmt.Sprintf("localhost:%d", port)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := client.Append("Hello, World!"); err != nil {
+		t.Fatal(err)
+	}
+	cluster.Close()
+	err = client.checkView(cluster.ViewID() + 1)
+	if err != nil {
+		t.Fatalf("Expected: %v, Actual: %v", nil, err)
+	}
+	if len(client.view) != 2 {
+		t.Fatalf("Expected: %d, Actual: %d", 2, len(client.view))
+	}
+}
+
+func TestCheckViewUnreachableDiscovery(t *testing.T) {
+	cluster := newTestCluster(t)
+	defer cluster.Close()
+	var failing, queries int32
+	entered := make(chan struct{}, 1)
+	release := make(chan struct{})
+	interceptor := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if !strings.HasSuffix(method, "/DiscoverServers") || atomic.LoadInt32(&failing) == 0 {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		atomic.AddInt32(&queries, 1)
+		select {
+		case entered <- struct{}{}:
+		default:
+		}
+		<-release
+		return status.Error(codes.Unavailable, "discovery unreachable")
+	}
+	client := newTestClient(t, cluster, WithUnaryInterceptors(interceptor))
+	defer client.Close()
+	atomic.StoreInt32(&failing, 1)
+	viewID := cluster.ViewID() + 1
+	errs := make(chan error, 1)
+	go func() {
+		errs <- client.checkView(viewID)
+	}()
+	select {
+	case <-entered:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the discovery service to be queried")
+	}
+
+	// The view is used while the discovery service is queried, by one caller
+	read := make(chan struct{})
+	go func() {
+		client.getView()
+		close(read)
+	}()
+	select {
+	case <-read:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the view to be readable during the query")
+	}
+	if err := client.checkView(viewID); err != nil {
+		t.Fatalf("Expected: %v, Actual: %v", nil, err)
+	}
+	close(release)
+	if err := <-errs; err != nil {
+		t.Fatalf("Expected: %v, Actual: %v", nil, err)
+	}
+	if n := atomic.LoadInt32(&queries); n != 1 {
+		t.Fatalf("Expected: %d, Actual: %d", 1, n)
+	}
+
+	// The discovery service is queried again for the view only after a backoff
+	if err := client.checkView(viewID); err != nil {
+		t.Fatalf("Expected: %v, Actual: %v", nil, err)
+	}
+	if n := atomic.LoadInt32(&queries); n != 1 {
+		t.Fatalf("Expected: %d, Actual: %d", 1, n)
+	}
+	time.Sleep(discoveryRetryBackoff)
+	atomic.StoreInt32(&failing, 0)
+	if err := client.checkView(viewID); err != nil {
+		t.Fatal(err)
+	}
+	client.viewMu.RLock()
+	defer client.viewMu.RUnlock()
+	if client.viewID != viewID {
+		t.Fatalf("Expected: %d, Actual: %d", viewID, client.viewID)
+	}
+}
@@ -0,0 +1,145 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	// ErrShardNotFound indicates that a shard is not in the client's view.
+	ErrShardNotFound = errors.New("shard not found")
+	// ErrRecordTrimmed indicates that a record has been trimmed. Data servers
+	// are expected to report reads of trimmed records with codes.OutOfRange.
+	ErrRecordTrimmed = errors.New("record trimmed")
+	// ErrNotCommitted indicates that a record has not been committed yet.
+	// Data servers are expected to report reads of records they do not hold
+	// with codes.NotFound.
+	ErrNotCommitted = errors.New("record not committed")
+	// ErrUnavailable indicates that a Scalog service could not be reached or
+	// did not answer in time, reported by gRPC with codes.Unavailable,
+	// codes.DeadlineExceeded or codes.ResourceExhausted.
+	ErrUnavailable = errors.New("service unavailable")
+	// ErrClosed indicates that the client has been closed.
+	ErrClosed = errors.New("client closed")
+)
+
+// OpError describes the failure of a client operation. It matches the sentinel
+// error describing the failure with errors.Is, and unwraps to the error
+// returned by the Scalog service, if any.
+type OpError struct {
+	// Operation that failed, such as Append, Read, Trim, Subscribe or
+	// DiscoverServers
+	Op string
+	// Identifier of the shard the operation targeted, or -1 if none
+	ShardID int32
+	// Global sequence number the operation targeted, or -1 if none
+	Gsn int32
+	// Sentinel error describing the failure, or nil if the failure does not
+	// match any sentinel
+	Kind error
+	// Error returned by the Scalog service, or nil if none
+	Err error
+}
+
+// Error returns a description of the failure.
+func (e *OpError) Error() string {
+	msg := e.Op
+	if e.ShardID >= 0 {
+		msg += fmt.Sprintf(" shard %d", e.ShardID)
+	}
+	if e.Gsn >= 0 {
+		msg += fmt.Sprintf(" gsn %d", e.Gsn)
+	}
+	if e.Kind != nil {
+		msg += ": " + e.Kind.Error()
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Is returns whether target is the sentinel error describing the failure.
+func (e *OpError) Is(target error) bool {
+	return e.Kind != nil && target == e.Kind
+}
+
+// Unwrap returns the error returned by the Scalog service.
+func (e *OpError) Unwrap() error {
+	return e.Err
+}
+
+// GRPCStatus returns the gRPC status of the error returned by the Scalog
+// service, or nil if the operation failed without an RPC error, such as when
+// dialing or locally.
+func (e *OpError) GRPCStatus() *status.Status {
+	if e.Err == nil {
+		return nil
+	}
+	s, ok := status.FromError(e.Err)
+	if !ok {
+		return nil
+	}
+	return s
+}
+
+// newOpError returns an OpError describing the failure of an operation with
+// err, or nil if err is nil. The sentinel error is determined by the gRPC
+// status code of err.
+func newOpError(op string, shardID int32, gsn int32, err error) error {
+	if err == nil {
+		return nil
+	}
+	var opErr *OpError
+	if errors.As(err, &opErr) {
+		return err
+	}
+	return &OpError{
+		Op:      op,
+		ShardID: shardID,
+		Gsn:     gsn,
+		Kind:    kindOfStatus(status.Code(err)),
+		Err:     err,
+	}
+}
+
+// kindOfStatus returns the sentinel error a gRPC status code maps to, or nil
+// if it maps to none. Records missing from data servers are only recognized
+// if the servers report them with the codes documented on ErrNotCommitted and
+// ErrRecordTrimmed, as the servers of scalogtest do; other servers' errors
+// are returned without a sentinel.
+func kindOfStatus(code codes.Code) error {
+	switch code {
+	case codes.NotFound:
+		return ErrNotCommitted
+	case codes.OutOfRange:
+		return ErrRecordTrimmed
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+		return ErrUnavailable
+	}
+	return nil
+}
+
+// isRecordMissing returns whether an error indicates that the requested record
+// is not held by the data server, either because it has been trimmed or
+// because it has not been committed yet.
+func isRecordMissing(err error) bool {
+	if errors.Is(err, ErrRecordTrimmed) || errors.Is(err, ErrNotCommitted) {
+		return true
+	}
+	kind := kindOfStatus(status.Code(err))
+	return kind == ErrRecordTrimmed || kind == ErrNotCommitted
+}
+
+// isClosed returns whether the client has been closed.
+func (c *Client) isClosed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,45 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestOpErrorStatusMapping(t *testing.T) {
+	for code, kind := range map[codes.Code]error{
+		codes.NotFound:         ErrNotCommitted,
+		codes.OutOfRange:       ErrRecordTrimmed,
+		codes.Unavailable:      ErrUnavailable,
+		codes.DeadlineExceeded: ErrUnavailable,
+	} {
+		err := newOpError("Read", 0, 7, status.Error(code, "rpc error"))
+		if !errors.Is(err, kind) {
+			t.Fatalf("Expected %s to map to %v, Actual: %v", code, kind, err)
+		}
+		var opErr *OpError
+		if !errors.As(err, &opErr) || opErr.ShardID != 0 || opErr.Gsn != 7 {
+			t.Fatalf("Expected OpError for shard 0 and gsn 7, Actual: %v", err)
+		}
+		if status.Code(err) != code {
+			t.Fatalf("Expected: %s, Actual: %s", code, status.Code(err))
+		}
+	}
+	if err := newOpError("Read", 0, 7, nil); err != nil {
+		t.Fatalf("Expected: nil, Actual: %v", err)
+	}
+}
+
+func TestOpErrorWithoutStatus(t *testing.T) {
+	for _, err := range []error{
+		newOpError("Read", 0, 7, fmt.Errorf("Failed to dial")),
+		&OpError{Op: "Read", ShardID: 0, Gsn: 7, Kind: ErrShardNotFound},
+	} {
+		if isServerFailure(err) {
+			t.Fatalf("Expected %v not to be a server failure", err)
+		}
+	}
+}
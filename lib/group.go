@@ -0,0 +1,518 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// groupRecordPrefix is the prefix of the control records consumer groups
+	// write to the log. Data records must not start with it.
+	groupRecordPrefix = "scalog-group:"
+	// defaultHeartbeatInterval is the interval at which group members write
+	// heartbeat records if none is configured.
+	defaultHeartbeatInterval = time.Second
+	// defaultSessionTimeout is the time after its last heartbeat after which a
+	// member is removed from its group if none is configured.
+	defaultSessionTimeout = 10 * time.Second
+)
+
+// Kinds of control records written by consumer groups.
+const (
+	groupJoin      = "join"
+	groupLeave     = "leave"
+	groupHeartbeat = "heartbeat"
+	groupSync      = "sync"
+)
+
+// GroupConfig configures a member of a consumer group.
+type GroupConfig struct {
+	// Name of the group
+	Group string
+	// Identifier of the member, unique in the group and stable across
+	// restarts so that the member resumes from its checkpoint
+	Member string
+	// Store of the member's checkpoint, saved under group/member
+	Store CheckpointStore
+	// Global sequence number from which the group's records are read, which
+	// must precede the group's first control record and not be trimmed
+	Start int32
+	// Function returning the key of a data record, or nil to use the whole
+	// record as key
+	Key func(record string) string
+	// Interval at which the member writes heartbeat records, or 0 for one
+	// second
+	HeartbeatInterval time.Duration
+	// Time after its last heartbeat after which a member is removed from the
+	// group, or 0 for ten seconds
+	SessionTimeout time.Duration
+	// Interval at which the member's checkpoint is committed, or 0 to commit
+	// only with Commit and Leave
+	CommitInterval time.Duration
+}
+
+// GroupMember is a member of a consumer group, which splits the data records
+// of the log across its members by key hash. Members read the whole log from
+// the group's start, and agree on the membership from the join, leave and
+// heartbeat records in it. Every membership change starts a generation, in
+// which each key is owned by one member. Records of a generation are delivered
+// only after every member that stays in the group has written a sync record
+// acknowledging that it handled the records before the change, so that records
+// with the same key are processed in order across rebalances. A member writes
+// its sync record only once the records of earlier generations it owned are
+// delivered and processed. Delivery is at-least-once: a member resumes from
+// its checkpoint after a restart, and the records of a member that dies
+// without restarting are not redelivered to other members.
+type GroupMember struct {
+	// Settings of the member
+	config GroupConfig
+	// Client reading and writing the group's records
+	client *Client
+	// Channel on which the member's data records are delivered
+	records chan CommittedRecord
+	// Global sequence number from which data records are delivered
+	checkpoint int32
+	// Global sequence number saved by the last commit
+	committed int32
+	// Global sequence number after the last record handled
+	nextGsn int32
+	// Records delivered but not processed, in order
+	inflight []groupRecord
+	// Records owned by the member awaiting their generation's sync, in order
+	pending []groupRecord
+	// Generations the member must sync once the records of earlier
+	// generations are processed, in order
+	owed []int
+	// Current generation, 0 before the first member joins
+	generation int
+	// Members of the current generation in order
+	members []string
+	// Map from member to the time of its last control record
+	lastSeen map[string]time.Time
+	// Map from member to the last generation it synced
+	synced map[string]int
+	// Map from generation to the members that must sync it, for generations
+	// not yet synced by all of them
+	required map[int][]string
+	// Mutex for accessing the state of the group
+	mu sync.Mutex
+	// Channel signaled when records are marked processed
+	processed chan struct{}
+	// Channel closed when the member leaves
+	done chan struct{}
+	// Ensures the member leaves only once
+	leaveOnce sync.Once
+}
+
+// groupMessage is the content of a control record, in JSON after
+// groupRecordPrefix.
+type groupMessage struct {
+	Group      string    `json:"group"`
+	Member     string    `json:"member"`
+	Type       string    `json:"type"`
+	Time       time.Time `json:"time"`
+	Generation int       `json:"generation,omitempty"`
+}
+
+// groupRecord is a data record owned by a member in a generation.
+type groupRecord struct {
+	record     CommittedRecord
+	generation int
+}
+
+// JoinGroup makes the client a member of a consumer group. The member reads
+// the log from the group's start, writes a join record, and delivers the data
+// records it owns on Records. A client may be the member of one group, and may
+// not subscribe otherwise.
+func (c *Client) JoinGroup(config GroupConfig) (*GroupMember, error) {
+	if config.Group == "" || config.Member == "" {
+		return nil, fmt.Errorf("Consumer group and member must be specified")
+	}
+	if config.Store == nil {
+		return nil, fmt.Errorf("Consumer group member %s needs a checkpoint store", config.Member)
+	}
+	if config.HeartbeatInterval == 0 {
+		config.HeartbeatInterval = defaultHeartbeatInterval
+	}
+	if config.SessionTimeout == 0 {
+		config.SessionTimeout = defaultSessionTimeout
+	}
+	checkpoint, in, err := config.Store.Load(checkpointName(config.Group, config.Member))
+	if err != nil {
+		return nil, err
+	}
+	if !in || checkpoint < config.Start {
+		checkpoint = config.Start
+	}
+	m := &GroupMember{
+		config:     config,
+		client:     c,
+		records:    make(chan CommittedRecord),
+		checkpoint: checkpoint,
+		committed:  checkpoint,
+		nextGsn:    config.Start,
+		lastSeen:   make(map[string]time.Time),
+		synced:     make(map[string]int),
+		required:   make(map[int][]string),
+		processed:  make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	subscribeChan, err := c.Subscribe(config.Start)
+	if err != nil {
+		return nil, err
+	}
+	err = m.write(groupJoin, 0)
+	if err != nil {
+		return nil, err
+	}
+	c.logger.Info("Joined consumer group", "group", config.Group, "member", config.Member, "checkpoint", checkpoint)
+	go m.dispatch(subscribeChan)
+	go m.heartbeat()
+	return m, nil
+}
+
+// Records returns the channel on which the data records owned by the member
+// are delivered in order of global sequence number.
+func (m *GroupMember) Records() <-chan CommittedRecord {
+	return m.records
+}
+
+// Members returns the members of the group in the current generation, as
+// observed by the member.
+func (m *GroupMember) Members() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	members := make([]string, len(m.members))
+	copy(members, m.members)
+	return members
+}
+
+// Generation returns the current generation of the group, as observed by the
+// member.
+func (m *GroupMember) Generation() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.generation
+}
+
+// MarkProcessed marks the records delivered up to and including a global
+// sequence number as processed.
+func (m *GroupMember) MarkProcessed(gsn int32) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := 0
+	for ; i < len(m.inflight) && m.inflight[i].record.Gsn <= gsn; i++ {
+	}
+	m.inflight = m.inflight[i:]
+	if i > 0 {
+		select {
+		case m.processed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Commit saves the position before the first record the member has not
+// processed to its checkpoint store.
+func (m *GroupMember) Commit() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	position := m.nextGsn
+	if len(m.pending) > 0 {
+		position = m.pending[0].record.Gsn
+	}
+	if len(m.inflight) > 0 {
+		position = m.inflight[0].record.Gsn
+	}
+	if position <= m.committed {
+		return nil
+	}
+	err := m.config.Store.Save(checkpointName(m.config.Group, m.config.Member), position)
+	if err != nil {
+		return err
+	}
+	m.committed = position
+	return nil
+}
+
+// Leave commits the member's checkpoint, writes a leave record so that the
+// other members take over its keys, and stops the member. Records are no
+// longer delivered once Leave returns.
+func (m *GroupMember) Leave() error {
+	var err error
+	m.leaveOnce.Do(func() {
+		close(m.done)
+		err = m.Commit()
+		if writeErr := m.write(groupLeave, 0); err == nil {
+			err = writeErr
+		}
+	})
+	return err
+}
+
+// checkpointName returns the name of the checkpoint of a member of a group.
+func checkpointName(group string, member string) string {
+	return group + "/" + member
+}
+
+// assignMember returns the member of a generation that owns a key.
+func assignMember(key string, members []string) string {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return members[h.Sum32()%uint32(len(members))]
+}
+
+// write appends a control record of the member to the log.
+func (m *GroupMember) write(kind string, generation int) error {
+	b, err := json.Marshal(groupMessage{
+		Group:      m.config.Group,
+		Member:     m.config.Member,
+		Type:       kind,
+		Time:       time.Now(),
+		Generation: generation,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = m.client.Append(groupRecordPrefix + string(b))
+	return err
+}
+
+// heartbeat writes heartbeat records until the member leaves or its client is
+// closed.
+func (m *GroupMember) heartbeat() {
+	ticker := time.NewTicker(m.config.HeartbeatInterval)
+	defer ticker.Stop()
+	var commit <-chan time.Time
+	if m.config.CommitInterval > 0 {
+		commitTicker := time.NewTicker(m.config.CommitInterval)
+		defer commitTicker.Stop()
+		commit = commitTicker.C
+	}
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-m.client.done:
+			return
+		case <-ticker.C:
+			if err := m.write(groupHeartbeat, 0); err != nil {
+				m.client.logger.Warn("Failed to write consumer group heartbeat", "group", m.config.Group, "member", m.config.Member, "err", err)
+			}
+		case <-commit:
+			if err := m.Commit(); err != nil {
+				m.client.logger.Warn("Failed to commit consumer group member", "group", m.config.Group, "member", m.config.Member, "err", err)
+			}
+		}
+	}
+}
+
+// dispatch handles the records of the log in order until the member leaves or
+// its client is closed.
+func (m *GroupMember) dispatch(subscribeChan chan CommittedRecord) {
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-m.client.done:
+			return
+		case record := <-subscribeChan:
+			m.handle(record)
+		case <-m.processed:
+		}
+		for generation := m.nextSync(); generation > 0; generation = m.nextSync() {
+			if err := m.write(groupSync, generation); err != nil {
+				m.client.logger.Warn("Failed to write consumer group sync", "group", m.config.Group, "member", m.config.Member, "err", err)
+			}
+		}
+		if !m.deliver() {
+			return
+		}
+	}
+}
+
+// handle updates the membership with a control record of the member's group,
+// skips control records of other groups and malformed ones, and queues a data
+// record for delivery if the member owns it.
+func (m *GroupMember) handle(record CommittedRecord) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextGsn = record.Gsn + 1
+	if strings.HasPrefix(record.Record, groupRecordPrefix) {
+		if message, ok := m.parse(record.Record); ok {
+			if generation := m.apply(record.Gsn, message); generation > 0 {
+				m.owed = append(m.owed, generation)
+			}
+			m.prune()
+		}
+		return
+	}
+	if len(m.members) == 0 {
+		return
+	}
+	key := record.Record
+	if m.config.Key != nil {
+		key = m.config.Key(record.Record)
+	}
+	if record.Gsn >= m.checkpoint && assignMember(key, m.members) == m.config.Member {
+		m.pending = append(m.pending, groupRecord{record: record, generation: m.generation})
+	}
+}
+
+// parse returns the control record of the member's group in a record, and
+// whether the record is one.
+func (m *GroupMember) parse(record string) (groupMessage, bool) {
+	var message groupMessage
+	err := json.Unmarshal([]byte(strings.TrimPrefix(record, groupRecordPrefix)), &message)
+	if err != nil || message.Group != m.config.Group {
+		return message, false
+	}
+	return message, true
+}
+
+// apply updates the membership with a control record, and returns the
+// generation the member must sync, or 0 if none. The caller must hold mu.
+func (m *GroupMember) apply(gsn int32, message groupMessage) int {
+	members := make(map[string]bool, len(m.members))
+	for _, member := range m.members {
+		members[member] = true
+	}
+	for member, lastSeen := range m.lastSeen {
+		if message.Time.Sub(lastSeen) > m.config.SessionTimeout {
+			delete(members, member)
+			delete(m.lastSeen, member)
+		}
+	}
+	switch message.Type {
+	case groupJoin, groupHeartbeat:
+		members[message.Member] = true
+		m.lastSeen[message.Member] = message.Time
+	case groupLeave:
+		delete(members, message.Member)
+		delete(m.lastSeen, message.Member)
+		delete(m.synced, message.Member)
+	case groupSync:
+		if message.Generation > m.synced[message.Member] {
+			m.synced[message.Member] = message.Generation
+		}
+	}
+	next := make([]string, 0, len(members))
+	for member := range members {
+		next = append(next, member)
+	}
+	sort.Strings(next)
+	if equalMembers(next, m.members) {
+		return 0
+	}
+	// Members staying in the group must sync the new generation
+	var required []string
+	for _, member := range m.members {
+		if members[member] {
+			required = append(required, member)
+		}
+	}
+	m.generation++
+	m.members = next
+	m.required[m.generation] = required
+	m.client.logger.Info("Consumer group rebalanced", "group", m.config.Group, "generation", m.generation, "members", strings.Join(next, ","), "gsn", gsn)
+	if members[m.config.Member] && gsn >= m.checkpoint {
+		for _, member := range required {
+			if member == m.config.Member {
+				return m.generation
+			}
+		}
+	}
+	return 0
+}
+
+// nextSync returns the next generation the member must sync if every record
+// of an earlier generation that it owned has been delivered and processed, or
+// 0 otherwise.
+func (m *GroupMember) nextSync() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.owed) == 0 {
+		return 0
+	}
+	generation := m.owed[0]
+	if len(m.inflight) > 0 && m.inflight[0].generation < generation {
+		return 0
+	}
+	if len(m.pending) > 0 && m.pending[0].generation < generation {
+		return 0
+	}
+	m.owed = m.owed[1:]
+	return generation
+}
+
+// isSynced returns whether every member required to sync a generation has
+// synced it or left the group. The caller must hold mu.
+func (m *GroupMember) isSynced(generation int) bool {
+	for g, required := range m.required {
+		if g > generation {
+			continue
+		}
+		for _, member := range required {
+			if _, in := m.lastSeen[member]; in && m.synced[member] < g {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// prune forgets the members required to sync the generations that every one
+// of them has synced. The caller must hold mu.
+func (m *GroupMember) prune() {
+	for g := range m.required {
+		if m.isSynced(g) {
+			delete(m.required, g)
+		}
+	}
+}
+
+// deliver sends the pending records whose generation is synced, and returns
+// false if the member left or its client was closed while sending.
+func (m *GroupMember) deliver() bool {
+	for {
+		m.mu.Lock()
+		if len(m.pending) == 0 || !m.isSynced(m.pending[0].generation) {
+			m.mu.Unlock()
+			return true
+		}
+		record := m.pending[0].record
+		m.mu.Unlock()
+		select {
+		case m.records <- record:
+		case <-m.done:
+			return false
+		case <-m.client.done:
+			return false
+		}
+		m.mu.Lock()
+		m.inflight = append(m.inflight, m.pending[0])
+		m.pending = m.pending[1:]
+		if len(m.pending) == 0 {
+			m.pending = nil
+		}
+		m.mu.Unlock()
+	}
+}
+
+// equalMembers returns whether two sorted lists of members are equal.
+func equalMembers(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
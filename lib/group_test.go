@@ -0,0 +1,259 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAssignMember(t *testing.T) {
+	members := []string{"a", "b", "c"}
+	owners := make(map[string]bool)
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		owner := assignMember(key, members)
+		if again := assignMember(key, members); again != owner {
+			t.Fatalf("Expected: %s, Actual: %s", owner, again)
+		}
+		owners[owner] = true
+	}
+	if len(owners) != len(members) {
+		t.Fatalf("Expected: %d, Actual: %d", len(members), len(owners))
+	}
+}
+
+// waitForMembers waits until a member observes the given members.
+func waitForMembers(t *testing.T, member *GroupMember, expected ...string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !equalMembers(member.Members(), expected) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected: %v, Actual: %v", expected, member.Members())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConsumerGroup(t *testing.T) {
+	cluster := newTestCluster(t)
+	defer cluster.Close()
+	producer := newTestClient(t, cluster)
+	defer producer.Close()
+	store := NewMemoryCheckpointStore()
+	key := func(record string) string {
+		return strings.SplitN(record, ":", 2)[0]
+	}
+	members := make(map[string]*GroupMember)
+	for _, name := range []string{"a", "b"} {
+		client := newTestClient(t, cluster)
+		defer client.Close()
+		member, err := client.JoinGroup(GroupConfig{Group: "orders", Member: name, Store: store, Start: 1, Key: key})
+		if err != nil {
+			t.Fatal(err)
+		}
+		members[name] = member
+	}
+	for _, member := range members {
+		waitForMembers(t, member, "a", "b")
+	}
+
+	const count = 20
+	var mu sync.Mutex
+	owners := make(map[string]string)
+	last := make(map[string]int32)
+	received := 0
+	var wg sync.WaitGroup
+	for name, member := range members {
+		wg.Add(1)
+		go func(name string, member *GroupMember) {
+			defer wg.Done()
+			for {
+				select {
+				case record := <-member.Records():
+					mu.Lock()
+					k := key(record.Record)
+					if owner, in := owners[k]; in && owner != name {
+						t.Errorf("Expected: %s, Actual: %s", owner, name)
+					}
+					if record.Gsn <= last[k] {
+						t.Errorf("Expected: gsn after %d, Actual: %d", last[k], record.Gsn)
+					}
+					owners[k] = name
+					last[k] = record.Gsn
+					received++
+					mu.Unlock()
+					member.MarkProcessed(record.Gsn)
+				case <-time.After(500 * time.Millisecond):
+					return
+				}
+			}
+		}(name, member)
+	}
+	for i := 0; i < count; i++ {
+		if _, err := producer.Append(fmt.Sprintf("key-%d:%d", i%5, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+	if received != count {
+		t.Fatalf("Expected: %d, Actual: %d", count, received)
+	}
+
+	// Keys of a member that leaves are taken over by the others
+	if err := members["b"].Leave(); err != nil {
+		t.Fatal(err)
+	}
+	if gsn, in, _ := store.Load("orders/b"); !in || gsn <= 1 {
+		t.Fatalf("Expected: %s, Actual: %d (in %v)", "checkpoint of b", gsn, in)
+	}
+	waitForMembers(t, members["a"], "a")
+	for i := 0; i < 5; i++ {
+		if _, err := producer.Append(fmt.Sprintf("key-%d:%d", i, count+i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		record := receive(t, members["a"].Records())
+		if expected := fmt.Sprintf("key-%d:%d", i, count+i); record.Record != expected {
+			t.Fatalf("Expected: %s, Actual: %s", expected, record.Record)
+		}
+	}
+	members["a"].Leave()
+}
+
+func TestConsumerGroupRebalanceInFlight(t *testing.T) {
+	cluster := newTestCluster(t)
+	defer cluster.Close()
+	producer := newTestClient(t, cluster)
+	defer producer.Close()
+	store := NewMemoryCheckpointStore()
+	key := func(record string) string {
+		return strings.SplitN(record, ":", 2)[0]
+	}
+	var clients []*Client
+	defer func() {
+		for _, client := range clients {
+			client.Close()
+		}
+	}()
+	join := func(name string) *GroupMember {
+		client := newTestClient(t, cluster)
+		clients = append(clients, client)
+		member, err := client.JoinGroup(GroupConfig{Group: "orders", Member: name, Store: store, Start: 1, Key: key})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return member
+	}
+
+	var mu sync.Mutex
+	last := make(map[string]int32)
+	busy := make(map[string]map[string]int)
+	received := 0
+	stop := make(chan struct{})
+	defer close(stop)
+	// consume processes the records of a member slowly, so that records are
+	// still in flight when the group rebalances
+	consume := func(name string, member *GroupMember) {
+		for {
+			select {
+			case record := <-member.Records():
+				k := key(record.Record)
+				mu.Lock()
+				if strings.HasPrefix(record.Record, groupRecordPrefix) {
+					t.Errorf("Expected: %s, Actual: %s", "data record", record.Record)
+				}
+				for other, n := range busy[k] {
+					if other != name && n > 0 {
+						t.Errorf("Expected: %s processed by %s first, Actual: delivered to %s", k, other, name)
+					}
+				}
+				if record.Gsn <= last[k] {
+					t.Errorf("Expected: gsn after %d, Actual: %d", last[k], record.Gsn)
+				}
+				last[k] = record.Gsn
+				if busy[k] == nil {
+					busy[k] = make(map[string]int)
+				}
+				busy[k][name]++
+				mu.Unlock()
+				time.Sleep(100 * time.Millisecond)
+				mu.Lock()
+				busy[k][name]--
+				received++
+				mu.Unlock()
+				member.MarkProcessed(record.Gsn)
+			case <-stop:
+				return
+			}
+		}
+	}
+	members := make(map[string]*GroupMember)
+	for _, name := range []string{"a", "b"} {
+		members[name] = join(name)
+	}
+	for _, member := range members {
+		waitForMembers(t, member, "a", "b")
+	}
+	for name, member := range members {
+		go consume(name, member)
+	}
+
+	// Control records of other groups and malformed ones are not delivered
+	for _, record := range []string{groupRecordPrefix + "{", groupRecordPrefix + `{"group":"other","member":"x","type":"join"}`} {
+		if _, err := producer.Append(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	const count = 30
+	for i := 0; i < count; i++ {
+		k := i % 5
+		if i >= count/3 {
+			// A member joins while records are in flight, and the first
+			// record after it has the key of the last record before it
+			k = (i - 1) % 5
+		}
+		if i == count/3 {
+			members["c"] = join("c")
+			go consume("c", members["c"])
+		}
+		if _, err := producer.Append(fmt.Sprintf("key-%d:%d", k, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		mu.Lock()
+		done := received
+		mu.Unlock()
+		if done == count {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected: %d, Actual: %d", count, done)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Generations synced by every member are forgotten
+	for name, member := range members {
+		waitForMembers(t, member, "a", "b", "c")
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			member.mu.Lock()
+			required := len(member.required)
+			member.mu.Unlock()
+			if required == 0 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected: %s to forget synced generations, Actual: %d", name, required)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	for _, member := range members {
+		member.Leave()
+	}
+}
@@ -0,0 +1,332 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	discovery "github.com/scalog/scalog/discovery/rpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// defaultFailureThreshold is the default number of consecutive failures after
+// which a data server is considered unhealthy.
+const defaultFailureThreshold = 3
+
+// defaultCooldown is the default period for which an unhealthy data server is
+// skipped before it is tried again.
+const defaultCooldown = 5 * time.Second
+
+// healthCheckMethod is the method of the standard gRPC health checking
+// protocol.
+const healthCheckMethod = "/grpc.health.v1.Health/Check"
+
+// healthCheckServing is the status reported by the gRPC health checking
+// protocol for a server that is serving.
+const healthCheckServing = 1
+
+// ServerState is the state of a data server's circuit breaker.
+type ServerState int
+
+const (
+	// ServerHealthy indicates that requests are sent to the server.
+	ServerHealthy ServerState = iota
+	// ServerUnhealthy indicates that the server is skipped until its
+	// cool-down period has elapsed.
+	ServerUnhealthy
+	// ServerRecovering indicates that the server's cool-down period has
+	// elapsed and the outcome of the next request determines its state.
+	ServerRecovering
+)
+
+// ServerHealth represents the health of a data server as observed by the
+// client.
+type ServerHealth struct {
+	// Identifier of the shard the server belongs to
+	ShardID int32
+	// Identifier of the server
+	ServerID int32
+	// State of the server's circuit breaker
+	State ServerState
+	// Number of consecutive failed requests to the server
+	ConsecutiveFailures int
+	// Error of the most recent failed request, or nil if none
+	LastError error
+	// Time of the most recent request or probe outcome
+	LastUpdated time.Time
+}
+
+// serverKey identifies a data server.
+type serverKey struct {
+	shardID  int32
+	serverID int32
+}
+
+// healthTracker tracks the health of data servers from the outcomes of
+// requests and probes, and implements a circuit breaker per server.
+type healthTracker struct {
+	// Number of consecutive failures after which a server is unhealthy
+	failureThreshold int
+	// Period for which an unhealthy server is skipped
+	cooldown time.Duration
+	// Map from server to its health
+	servers map[serverKey]*ServerHealth
+	// Map from unhealthy server to the time it became unhealthy
+	openedAt map[serverKey]time.Time
+	// Mutex for accessing servers and openedAt
+	mu sync.Mutex
+	// Receiver of state changes
+	logger Logger
+}
+
+// healthCheckRequest is the request message of the gRPC health checking
+// protocol.
+type healthCheckRequest struct {
+	Service string `protobuf:"bytes,1,opt,name=service,proto3"`
+}
+
+// healthCheckResponse is the response message of the gRPC health checking
+// protocol.
+type healthCheckResponse struct {
+	Status int32 `protobuf:"varint,1,opt,name=status,proto3"`
+}
+
+func (m *healthCheckRequest) Reset()         { *m = healthCheckRequest{} }
+func (m *healthCheckRequest) String() string { return proto.CompactTextString(m) }
+func (*healthCheckRequest) ProtoMessage()    {}
+
+func (m *healthCheckResponse) Reset()         { *m = healthCheckResponse{} }
+func (m *healthCheckResponse) String() string { return proto.CompactTextString(m) }
+func (*healthCheckResponse) ProtoMessage()    {}
+
+// WithCircuitBreaker sets the number of consecutive failed requests after
+// which a data server is considered unhealthy, and the cool-down period for
+// which an unhealthy server is skipped in favor of other replicas.
+func WithCircuitBreaker(failureThreshold int, cooldown time.Duration) Option {
+	return func(c *Client) error {
+		if failureThreshold < 1 {
+			return fmt.Errorf("Failure threshold must be greater than 0")
+		}
+		c.health.failureThreshold = failureThreshold
+		c.health.cooldown = cooldown
+		return nil
+	}
+}
+
+// WithHealthProbes periodically probes every data server in the view with the
+// standard gRPC health checking protocol, in addition to tracking the outcomes
+// of requests. Servers that do not implement the protocol are considered
+// healthy as long as they can be reached.
+func WithHealthProbes(interval time.Duration) Option {
+	return func(c *Client) error {
+		if interval <= 0 {
+			return fmt.Errorf("Probe interval must be greater than 0")
+		}
+		c.probeInterval = interval
+		return nil
+	}
+}
+
+// Health returns the health of every data server in the client's view,
+// ordered by shard and server identifier.
+func (c *Client) Health() []ServerHealth {
+	shards := c.getView()
+	health := make([]ServerHealth, 0)
+	for _, shard := range shards {
+		for _, server := range shard.Servers {
+			health = append(health, c.health.get(shard.ShardID, server.ServerID))
+		}
+	}
+	sort.Slice(health, func(i, j int) bool {
+		if health[i].ShardID != health[j].ShardID {
+			return health[i].ShardID < health[j].ShardID
+		}
+		return health[i].ServerID < health[j].ServerID
+	})
+	return health
+}
+
+// String returns the name of a server state.
+func (s ServerState) String() string {
+	switch s {
+	case ServerHealthy:
+		return "healthy"
+	case ServerUnhealthy:
+		return "unhealthy"
+	case ServerRecovering:
+		return "recovering"
+	}
+	return fmt.Sprintf("ServerState(%d)", int(s))
+}
+
+// newHealthTracker returns a new instance of healthTracker.
+func newHealthTracker() *healthTracker {
+	return &healthTracker{
+		failureThreshold: defaultFailureThreshold,
+		cooldown:         defaultCooldown,
+		servers:          make(map[serverKey]*ServerHealth),
+		openedAt:         make(map[serverKey]time.Time),
+		logger:           nopLogger{},
+	}
+}
+
+// get returns the health of a server.
+func (ht *healthTracker) get(shardID int32, serverID int32) ServerHealth {
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+	return *ht.lookup(serverKey{shardID: shardID, serverID: serverID})
+}
+
+// available returns whether requests may be sent to a server, moving the
+// server to the recovering state if its cool-down period has elapsed.
+func (ht *healthTracker) available(shardID int32, serverID int32) bool {
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+	key := serverKey{shardID: shardID, serverID: serverID}
+	health := ht.lookup(key)
+	if health.State != ServerUnhealthy {
+		return true
+	}
+	if time.Since(ht.openedAt[key]) < ht.cooldown {
+		return false
+	}
+	health.State = ServerRecovering
+	return true
+}
+
+// report records the outcome of a request to a server. Errors that do not
+// indicate a failure of the server itself are ignored.
+func (ht *healthTracker) report(shardID int32, serverID int32, err error) {
+	if err != nil && !isServerFailure(err) {
+		return
+	}
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+	key := serverKey{shardID: shardID, serverID: serverID}
+	health := ht.lookup(key)
+	health.LastUpdated = time.Now()
+	if err == nil {
+		if health.State != ServerHealthy {
+			ht.logger.Info("Data server recovered", "shard", shardID, "server", serverID)
+		}
+		health.State = ServerHealthy
+		health.ConsecutiveFailures = 0
+		delete(ht.openedAt, key)
+		return
+	}
+	health.ConsecutiveFailures++
+	health.LastError = err
+	if health.State == ServerRecovering || health.ConsecutiveFailures >= ht.failureThreshold {
+		if health.State != ServerUnhealthy {
+			ht.logger.Warn("Data server marked unhealthy", "shard", shardID, "server", serverID, "failures", health.ConsecutiveFailures, "err", err)
+		}
+		health.State = ServerUnhealthy
+		ht.openedAt[key] = time.Now()
+	}
+}
+
+// lookup returns the health of a server, creating it if the server has not
+// been seen before. The caller must hold mu.
+func (ht *healthTracker) lookup(key serverKey) *ServerHealth {
+	health, in := ht.servers[key]
+	if !in {
+		health = &ServerHealth{
+			ShardID:  key.shardID,
+			ServerID: key.serverID,
+			State:    ServerHealthy,
+		}
+		ht.servers[key] = health
+	}
+	return health
+}
+
+// pickServer returns a random server in a shard that is not excluded,
+// preferring servers whose circuit breaker allows requests and then servers in
+// the client's zone. It returns nil if every server is excluded.
+func (c *Client) pickServer(shard *discovery.Shard, exclude map[*discovery.DataServer]bool) *discovery.DataServer {
+	available := make([]*discovery.DataServer, 0, len(shard.Servers))
+	remaining := make([]*discovery.DataServer, 0, len(shard.Servers))
+	for _, server := range shard.Servers {
+		if exclude[server] {
+			continue
+		}
+		remaining = append(remaining, server)
+		if c.health.available(shard.ShardID, server.ServerID) {
+			available = append(available, server)
+		}
+	}
+	if len(available) == 0 {
+		available = remaining
+	}
+	if len(available) == 0 {
+		return nil
+	}
+	config := c.getConfig()
+	available = config.preferLocal(available)
+	seed := rand.NewSource(time.Now().UnixNano())
+	server := available[rand.New(seed).Intn(len(available))]
+	if config.Zone != "" {
+		if zone := config.zoneOf(server); zone != config.Zone {
+			c.metrics.ObserveCrossZone(shard.ShardID, zone)
+		}
+	}
+	return server
+}
+
+// probe periodically checks the health of every data server in the view
+// until the client is closed.
+func (c *Client) probe() {
+	ticker := time.NewTicker(c.probeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+		shards := c.getView()
+		for _, shard := range shards {
+			for _, server := range shard.Servers {
+				go c.probeServer(shard.ShardID, server)
+			}
+		}
+	}
+}
+
+// probeServer checks the health of a data server with the gRPC health
+// checking protocol.
+func (c *Client) probeServer(shardID int32, server *discovery.DataServer) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.probeInterval)
+	defer cancel()
+	conn, err := c.dial(getAddressOfServer(server))
+	if err != nil {
+		c.health.report(shardID, server.ServerID, status.Error(codes.Unavailable, err.Error()))
+		return
+	}
+	defer conn.Close()
+	resp := &healthCheckResponse{}
+	err = conn.Invoke(ctx, healthCheckMethod, &healthCheckRequest{}, resp)
+	if status.Code(err) == codes.Unimplemented {
+		err = nil
+	} else if err == nil && resp.Status != healthCheckServing {
+		err = status.Errorf(codes.Unavailable, "Server %d reported health status %d", server.ServerID, resp.Status)
+	}
+	c.health.report(shardID, server.ServerID, err)
+}
+
+// isServerFailure returns whether an error returned by a request to a data
+// server indicates that the server itself failed, because it could not be
+// reached or did not answer in time. Errors returned by the server, including
+// codes.Internal and codes.Unknown, are not server failures.
+func isServerFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/scalog/scalog-client/scalogtest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	ht := newHealthTracker()
+	ht.failureThreshold = 2
+	ht.cooldown = 10 * time.Millisecond
+	unavailable := status.Error(codes.Unavailable, "unavailable")
+	ht.report(0, 1, unavailable)
+	if !ht.available(0, 1) {
+		t.Fatalf("Expected server to be available below the failure threshold")
+	}
+	ht.report(0, 1, status.Error(codes.NotFound, "not found"))
+	ht.report(0, 1, unavailable)
+	if ht.available(0, 1) {
+		t.Fatalf("Expected server to be skipped during its cool-down period")
+	}
+	time.Sleep(ht.cooldown)
+	if !ht.available(0, 1) {
+		t.Fatalf("Expected server to be available after its cool-down period")
+	}
+	if state := ht.get(0, 1).State; state != ServerRecovering {
+		t.Fatalf("Expected: %s, Actual: %s", ServerRecovering, state)
+	}
+	ht.report(0, 1, nil)
+	health := ht.get(0, 1)
+	if health.State != ServerHealthy || health.ConsecutiveFailures != 0 {
+		t.Fatalf("Expected server to be healthy after a successful request, Actual: %+v", health)
+	}
+}
+
+// callCounter is an interceptor counting requests per method and target, and
+// failing the requests that fail returns an error for.
+type callCounter struct {
+	fail  func(method string, target string) error
+	calls map[string]int
+	mu    sync.Mutex
+}
+
+// intercept counts a request and fails it if fail returns an error.
+func (cc *callCounter) intercept(ctx context.Context, method string, req, reply interface{}, conn *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	name := method[strings.LastIndex(method, "/")+1:]
+	cc.mu.Lock()
+	cc.calls[name+" "+conn.Target()]++
+	cc.mu.Unlock()
+	if cc.fail != nil {
+		if err := cc.fail(name, conn.Target()); err != nil {
+			return err
+		}
+	}
+	return invoker(ctx, method, req, reply, conn, opts...)
+}
+
+// count returns the number of requests of a method sent to a target.
+func (cc *callCounter) count(method string, target string) int {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return cc.calls[method+" "+target]
+}
+
+func TestClientFailover(t *testing.T) {
+	cluster := newTestCluster(t)
+	defer cluster.Close()
+	var local, remote *scalogtest.Server
+	for _, server := range cluster.Servers() {
+		if server.ShardID() != 0 {
+			continue
+		}
+		if local == nil {
+			local = server
+		} else {
+			remote = server
+		}
+	}
+	ip, port := local.Address()
+	localTarget := fmt.Sprintf("%s:%d", ip, port)
+	ip, port = remote.Address()
+	remoteTarget := fmt.Sprintf("%s:%d", ip, port)
+	// Requests are sent to the replica in the client's zone first
+	newClient := func(counter *callCounter) *Client {
+		return newTestClient(t, cluster,
+			WithZone("a", Locality{Zone: "a", ServerIDs: []int32{local.ServerID()}}),
+			WithCircuitBreaker(2, time.Minute),
+			WithUnaryInterceptors(counter.intercept),
+		)
+	}
+
+	// Unreachable replicas are failed over and their circuit opens
+	counter := &callCounter{calls: make(map[string]int)}
+	client := newClient(counter)
+	defer client.Close()
+	local.SetFault(scalogtest.Fault{Unavailable: true})
+	gsn, err := client.appendToShard(client.getShard(0), 0, "Hello, World!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		record, err := client.ReadRecord(gsn, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record != "Hello, World!" {
+			t.Fatalf("Expected: %s, Actual: %s", "Hello, World!", record)
+		}
+	}
+	if health := client.health.get(0, local.ServerID()); health.State != ServerUnhealthy {
+		t.Fatalf("Expected: %v, Actual: %+v", ServerUnhealthy, health)
+	}
+	if health := client.health.get(0, remote.ServerID()); health.State != ServerHealthy {
+		t.Fatalf("Expected: %v, Actual: %+v", ServerHealthy, health)
+	}
+	// The circuit opened after the failed append and the first failed read
+	if count := counter.count("Read", localTarget); count != 1 {
+		t.Fatalf("Expected: %d, Actual: %d", 1, count)
+	}
+	local.SetFault(scalogtest.Fault{})
+
+	// Errors returned by a replica are neither failed over nor counted
+	counter = &callCounter{
+		calls: make(map[string]int),
+		fail: func(method string, target string) error {
+			if method == "Read" && target == localTarget {
+				return status.Error(codes.Internal, "Read failed")
+			}
+			return nil
+		},
+	}
+	other := newClient(counter)
+	defer other.Close()
+	for i := 0; i < 4; i++ {
+		if _, err := other.ReadRecord(gsn, 0); status.Code(err) != codes.Internal {
+			t.Fatalf("Expected: %v, Actual: %v", codes.Internal, err)
+		}
+	}
+	if count := counter.count("Read", remoteTarget); count != 0 {
+		t.Fatalf("Expected: %d, Actual: %d", 0, count)
+	}
+	if health := other.health.get(0, local.ServerID()); health.State != ServerHealthy || health.ConsecutiveFailures != 0 {
+		t.Fatalf("Expected: %v, Actual: %+v", ServerHealthy, health)
+	}
+
+	// Appends that time out are not sent to another replica
+	counter = &callCounter{
+		calls: make(map[string]int),
+		fail: func(method string, target string) error {
+			if method == "Append" && target == localTarget {
+				return status.Error(codes.DeadlineExceeded, "Append timed out")
+			}
+			return nil
+		},
+	}
+	slow := newClient(counter)
+	defer slow.Close()
+	if _, err := slow.appendToShard(slow.getShard(0), 0, "Hello, World!"); status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("Expected: %v, Actual: %v", codes.DeadlineExceeded, err)
+	}
+	if count := counter.count("Append", remoteTarget); count != 0 {
+		t.Fatalf("Expected: %d, Actual: %d", 0, count)
+	}
+}
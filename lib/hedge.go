@@ -0,0 +1,188 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	discovery "github.com/scalog/scalog/discovery/rpc"
+)
+
+// latencyWindowSize is the number of recent read latencies from which hedging
+// percentiles are computed.
+const latencyWindowSize = 256
+
+// minLatencySamples is the number of read latencies required before a
+// percentile is used as the hedging delay.
+const minLatencySamples = 16
+
+// hedgePolicy determines when a read is sent to a second replica.
+type hedgePolicy struct {
+	// Fixed delay after which a read is hedged, or the delay used until
+	// enough latencies have been observed if percentile is set
+	delay time.Duration
+	// Latency percentile in (0, 100) after which a read is hedged, or 0 if
+	// the fixed delay is always used
+	percentile float64
+	// Recent latencies of the first replica reads are sent to
+	latencies *latencyWindow
+}
+
+// latencyWindow is a ring buffer of recent latencies.
+type latencyWindow struct {
+	// Recorded latencies
+	samples []time.Duration
+	// Index in samples of the next latency to record
+	next int
+	// Mutex for accessing samples and next
+	mu sync.Mutex
+}
+
+// serverRead represents the result of reading a record from a data server.
+type serverRead struct {
+	record string
+	err    error
+}
+
+// WithHedgedReads enables hedged reads: if the replica a read is sent to has
+// not answered within delay, the same read is sent to a second replica of the
+// shard, the first answer is used and the other read is cancelled.
+func WithHedgedReads(delay time.Duration) Option {
+	return func(c *Client) error {
+		if delay <= 0 {
+			return fmt.Errorf("Hedging delay must be greater than 0")
+		}
+		c.hedge = &hedgePolicy{delay: delay}
+		return nil
+	}
+}
+
+// WithHedgedReadsPercentile enables hedged reads with a delay equal to a
+// percentile of the recently observed latencies of replicas. Until enough
+// latencies have been observed, reads are hedged after initialDelay. The read
+// sent to the first replica is not cancelled when the hedged read answers
+// first, so that its latency is observed even when it exceeds the delay.
+func WithHedgedReadsPercentile(percentile float64, initialDelay time.Duration) Option {
+	return func(c *Client) error {
+		if percentile <= 0 || percentile >= 100 {
+			return fmt.Errorf("Hedging percentile must be between 0 and 100")
+		}
+		if initialDelay <= 0 {
+			return fmt.Errorf("Hedging delay must be greater than 0")
+		}
+		c.hedge = &hedgePolicy{
+			delay:      initialDelay,
+			percentile: percentile,
+			latencies:  &latencyWindow{},
+		}
+		return nil
+	}
+}
+
+// hedgedRead reads a record with a global sequence number from a replica in a
+// shard, and sends the same read to a second replica if the first has not
+// answered within the hedging delay or has failed. The first successful answer
+// is used and the other read is cancelled, unless it was sent to the first
+// replica and the hedging delay is a percentile of observed latencies.
+func (c *Client) hedgedRead(ctx context.Context, shard *discovery.Shard, gsn int32) (string, error) {
+	firstCtx := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if c.hedge.latencies == nil {
+		firstCtx = ctx
+	}
+	reads := make(chan serverRead, 2)
+	read := func(ctx context.Context, server *discovery.DataServer, observe bool) {
+		start := time.Now()
+		record, err := c.readFromServer(ctx, shard.ShardID, server, gsn)
+		c.health.report(shard.ShardID, server.ServerID, err)
+		if observe && err == nil {
+			c.hedge.observe(time.Since(start))
+		}
+		reads <- serverRead{record: record, err: err}
+	}
+	first := c.pickServer(shard, nil)
+	go read(firstCtx, first, true)
+	outstanding := 1
+	hedged := false
+	hedge := func() {
+		hedged = true
+		second := c.pickServer(shard, map[*discovery.DataServer]bool{first: true})
+		if second != nil {
+			outstanding++
+			go read(ctx, second, false)
+		}
+	}
+	timer := time.NewTimer(c.hedge.nextDelay())
+	defer timer.Stop()
+	for {
+		select {
+		case result := <-reads:
+			outstanding--
+			if result.err == nil {
+				return result.record, nil
+			}
+			if !hedged && isServerFailure(result.err) {
+				hedge()
+			}
+			if outstanding == 0 {
+				return "", result.err
+			}
+		case <-timer.C:
+			if !hedged {
+				hedge()
+			}
+		}
+	}
+}
+
+// nextDelay returns the delay after which the next read is hedged.
+func (hp *hedgePolicy) nextDelay() time.Duration {
+	if hp.latencies == nil {
+		return hp.delay
+	}
+	delay, ok := hp.latencies.percentile(hp.percentile)
+	if !ok {
+		return hp.delay
+	}
+	return delay
+}
+
+// observe records the latency of a successful read from the first replica a
+// read was sent to.
+func (hp *hedgePolicy) observe(latency time.Duration) {
+	if hp.latencies != nil {
+		hp.latencies.record(latency)
+	}
+}
+
+// record adds a latency to the window, replacing the oldest latency if the
+// window is full.
+func (lw *latencyWindow) record(latency time.Duration) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	if len(lw.samples) < latencyWindowSize {
+		lw.samples = append(lw.samples, latency)
+		return
+	}
+	lw.samples[lw.next] = latency
+	lw.next = (lw.next + 1) % latencyWindowSize
+}
+
+// percentile returns a percentile of the latencies in the window, and whether
+// enough latencies have been recorded to compute it.
+func (lw *latencyWindow) percentile(percentile float64) (time.Duration, bool) {
+	lw.mu.Lock()
+	samples := make([]time.Duration, len(lw.samples))
+	copy(samples, lw.samples)
+	lw.mu.Unlock()
+	if len(samples) < minLatencySamples {
+		return 0, false
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i] < samples[j]
+	})
+	return samples[int(float64(len(samples)-1)*percentile/100)], true
+}
@@ -0,0 +1,165 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scalog/scalog-client/scalogtest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestHedgePolicyPercentile(t *testing.T) {
+	hp := &hedgePolicy{
+		delay:      time.Second,
+		percentile: 90,
+		latencies:  &latencyWindow{},
+	}
+	if delay := hp.nextDelay(); delay != time.Second {
+		t.Fatalf("Expected: %v, Actual: %v", time.Second, delay)
+	}
+	for i := 1; i <= 100; i++ {
+		hp.observe(time.Duration(i) * time.Millisecond)
+	}
+	if delay := hp.nextDelay(); delay != 90*time.Millisecond {
+		t.Fatalf("Expected: %v, Actual: %v", 90*time.Millisecond, delay)
+	}
+}
+
+// hedgeCall is a Read request observed by an interceptor.
+type hedgeCall struct {
+	target string
+	start  time.Time
+	err    error
+}
+
+func TestHedgedRead(t *testing.T) {
+	cluster := newTestCluster(t)
+	defer cluster.Close()
+	calls := make(chan hedgeCall, 16)
+	interceptor := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		if strings.HasSuffix(method, "/Read") {
+			calls <- hedgeCall{target: cc.Target(), start: start, err: err}
+		}
+		return err
+	}
+	var slow *scalogtest.Server
+	for _, server := range cluster.Servers() {
+		if server.ShardID() == 0 {
+			slow = server
+			break
+		}
+	}
+	// The slow replica is in the client's zone, so reads are sent to it first
+	delay := 50 * time.Millisecond
+	client := newTestClient(t, cluster,
+		WithHedgedReads(delay),
+		WithZone("a", Locality{Zone: "a", ServerIDs: []int32{slow.ServerID()}}),
+		WithUnaryInterceptors(interceptor),
+	)
+	defer client.Close()
+	var gsn int32
+	for {
+		var shardID int32
+		var err error
+		gsn, shardID, err = client.AppendToShard("Hello, World!")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if shardID == 0 {
+			break
+		}
+	}
+	slow.SetFault(scalogtest.Fault{Latency: 5 * time.Second})
+	ip, port := slow.Address()
+	slowTarget := fmt.Sprintf("%s:%d", ip, port)
+
+	start := time.Now()
+	record, err := client.ReadRecord(gsn, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record != "Hello, World!" {
+		t.Fatalf("Expected: %s, Actual: %s", "Hello, World!", record)
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Fatalf("Expected read faster than the slow replica, Actual: %v", elapsed)
+	}
+	var slowCall, fastCall hedgeCall
+	for i := 0; i < 2; i++ {
+		select {
+		case call := <-calls:
+			if call.target == slowTarget {
+				slowCall = call
+			} else {
+				fastCall = call
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected the slow read to be cancelled")
+		}
+	}
+	if fastCall.err != nil {
+		t.Fatal(fastCall.err)
+	}
+	if hedgedAfter := fastCall.start.Sub(start); hedgedAfter < delay {
+		t.Fatalf("Expected hedge after %v, Actual: %v", delay, hedgedAfter)
+	}
+	if status.Code(slowCall.err) != codes.Canceled {
+		t.Fatalf("Expected: %v, Actual: %v", codes.Canceled, slowCall.err)
+	}
+}
+
+func TestHedgedReadPercentile(t *testing.T) {
+	cluster := newTestCluster(t)
+	defer cluster.Close()
+	var slow *scalogtest.Server
+	for _, server := range cluster.Servers() {
+		if server.ShardID() == 0 {
+			slow = server
+			break
+		}
+	}
+	// The slow replica is in the client's zone, so reads are sent to it first
+	client := newTestClient(t, cluster,
+		WithHedgedReadsPercentile(50, 10*time.Millisecond),
+		WithZone("a", Locality{Zone: "a", ServerIDs: []int32{slow.ServerID()}}),
+	)
+	defer client.Close()
+	var gsn int32
+	for {
+		var shardID int32
+		var err error
+		gsn, shardID, err = client.AppendToShard("Hello, World!")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if shardID == 0 {
+			break
+		}
+	}
+	latency := 100 * time.Millisecond
+	slow.SetFault(scalogtest.Fault{Latency: latency})
+	for i := 0; i < 2*minLatencySamples; i++ {
+		start := time.Now()
+		if _, err := client.ReadRecord(gsn, 0); err != nil {
+			t.Fatal(err)
+		}
+		if elapsed := time.Since(start); i < minLatencySamples && elapsed >= latency {
+			t.Fatalf("Expected read faster than the slow replica, Actual: %v", elapsed)
+		}
+	}
+	// The delay follows the latency of the slow replica, not of hedged reads
+	deadline := time.Now().Add(5 * time.Second)
+	for client.hedge.nextDelay() < latency {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected delay of at least %v, Actual: %v", latency, client.hedge.nextDelay())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,112 @@
+package lib
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// loggingStream logs the end of a client stream.
+type loggingStream struct {
+	grpc.ClientStream
+	// Logger to which the end of the stream is logged
+	logger Logger
+	// Full gRPC method name of the stream
+	method string
+	// Time at which the stream was opened
+	start time.Time
+}
+
+// WithUnaryInterceptors adds interceptors to every unary request the client
+// sends. Interceptors run in the order given, before the client's own.
+func WithUnaryInterceptors(interceptors ...grpc.UnaryClientInterceptor) Option {
+	return func(c *Client) error {
+		c.unaryInterceptors = append(c.unaryInterceptors, interceptors...)
+		return nil
+	}
+}
+
+// WithStreamInterceptors adds interceptors to every streaming request the
+// client sends. Interceptors run in the order given, before the client's own.
+func WithStreamInterceptors(interceptors ...grpc.StreamClientInterceptor) Option {
+	return func(c *Client) error {
+		c.streamInterceptors = append(c.streamInterceptors, interceptors...)
+		return nil
+	}
+}
+
+// LoggingUnaryInterceptor returns an interceptor that logs the method,
+// duration and status code of every unary request, at LevelDebug if the
+// request succeeded and LevelWarn otherwise.
+func LoggingUnaryInterceptor(logger Logger) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		keyvals := []interface{}{"method", method, "target", cc.Target(), "code", status.Code(err), "elapsed", time.Since(start)}
+		if err != nil {
+			logger.Warn("Request failed", keyvals...)
+		} else {
+			logger.Debug("Request completed", keyvals...)
+		}
+		return err
+	}
+}
+
+// LoggingStreamInterceptor returns an interceptor that logs the opening and
+// end of every streaming request.
+func LoggingStreamInterceptor(logger Logger) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			logger.Warn("Failed to open stream", "method", method, "target", cc.Target(), "code", status.Code(err))
+			return nil, err
+		}
+		logger.Debug("Opened stream", "method", method, "target", cc.Target())
+		return &loggingStream{
+			ClientStream: stream,
+			logger:       logger,
+			method:       method,
+			start:        time.Now(),
+		}, nil
+	}
+}
+
+// SlowCallUnaryInterceptor returns an interceptor that calls report for every
+// unary request that takes longer than threshold.
+func SlowCallUnaryInterceptor(threshold time.Duration, report func(method string, elapsed time.Duration)) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		if elapsed := time.Since(start); elapsed > threshold {
+			report(method, elapsed)
+		}
+		return err
+	}
+}
+
+// timeoutUnaryInterceptor returns an interceptor that sets a deadline on every
+// unary request that does not have one.
+func timeoutUnaryInterceptor(timeout time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if _, ok := ctx.Deadline(); !ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// RecvMsg receives a message from the stream, logging the end of the stream.
+func (s *loggingStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err == io.EOF {
+		s.logger.Debug("Stream ended", "method", s.method, "elapsed", time.Since(s.start))
+	} else if err != nil {
+		s.logger.Warn("Stream failed", "method", s.method, "code", status.Code(err), "elapsed", time.Since(s.start))
+	}
+	return err
+}
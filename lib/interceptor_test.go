@@ -0,0 +1,48 @@
+package lib
+
+import (
+	"bytes"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	discovery "github.com/scalog/scalog/discovery/rpc"
+	"google.golang.org/grpc"
+)
+
+func TestInterceptors(t *testing.T) {
+	server := grpc.NewServer()
+	discovery.RegisterDiscoveryServer(server, staticDiscoveryServer{})
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(lis)
+	defer server.Stop()
+	port := int32(lis.Addr().(*net.TCPAddr).Port)
+
+	var buf bytes.Buffer
+	slowCalls := make([]string, 0)
+	client, err := NewClient(
+		WithDiscoveryAddress("127.0.0.1", port),
+		WithUnaryInterceptors(
+			LoggingUnaryInterceptor(NewStdLogger(log.New(&buf, "", 0), LevelDebug)),
+			SlowCallUnaryInterceptor(0, func(method string, elapsed time.Duration) {
+				slowCalls = append(slowCalls, method)
+			}),
+		),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	method := "/rpc.Discovery/DiscoverServers"
+	if !strings.Contains(buf.String(), method) {
+		t.Fatalf("Expected request log to contain %s, Actual: %s", method, buf.String())
+	}
+	if len(slowCalls) != 1 || slowCalls[0] != method {
+		t.Fatalf("Expected: [%s], Actual: %v", method, slowCalls)
+	}
+}
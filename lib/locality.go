@@ -0,0 +1,84 @@
+package lib
+
+import (
+	"fmt"
+	"path"
+
+	discovery "github.com/scalog/scalog/discovery/rpc"
+)
+
+// Locality assigns the data servers matching it to a zone. A server matches if
+// its identifier is listed in ServerIDs, or if the address the client connects
+// to, as ip:port, matches the glob pattern Address, such as "10.1.*".
+type Locality struct {
+	Zone      string  `yaml:"zone"`
+	ServerIDs []int32 `yaml:"server-ids,omitempty"`
+	Address   string  `yaml:"address,omitempty"`
+}
+
+// WithZone sets the zone of the client and the localities assigning data
+// servers to zones, overriding zone and localities in config.yaml. Requests
+// are sent to healthy replicas in the client's zone if there are any, and to
+// replicas in other zones otherwise. Replicas matching no locality are in
+// another zone.
+func WithZone(zone string, localities ...Locality) Option {
+	return func(c *Client) error {
+		c.config.Zone = zone
+		c.config.Localities = localities
+		return nil
+	}
+}
+
+// validateLocalities returns an error if a locality has no zone, matches no
+// server or has an invalid address pattern.
+func validateLocalities(localities []Locality) error {
+	for _, locality := range localities {
+		if locality.Zone == "" {
+			return fmt.Errorf("Locality without zone")
+		}
+		if len(locality.ServerIDs) == 0 && locality.Address == "" {
+			return fmt.Errorf("Locality of zone %s needs server-ids or address", locality.Zone)
+		}
+		if _, err := path.Match(locality.Address, ""); err != nil {
+			return fmt.Errorf("Invalid address pattern %q of zone %s: %v", locality.Address, locality.Zone, err)
+		}
+	}
+	return nil
+}
+
+// zoneOf returns the zone of a data server, the zone of the first locality it
+// matches, or an empty string if it matches none.
+func (c *config) zoneOf(server *discovery.DataServer) string {
+	address := getAddressOfServer(server)
+	for _, locality := range c.Localities {
+		for _, serverID := range locality.ServerIDs {
+			if serverID == server.ServerID {
+				return locality.Zone
+			}
+		}
+		if locality.Address != "" {
+			if matched, _ := path.Match(locality.Address, address); matched {
+				return locality.Zone
+			}
+		}
+	}
+	return ""
+}
+
+// preferLocal returns the servers in the zone of a configuration's client, or
+// all servers if the client has no zone or no server is in its zone.
+func (c *config) preferLocal(servers []*discovery.DataServer) []*discovery.DataServer {
+	if c.Zone == "" {
+		return servers
+	}
+	local := make([]*discovery.DataServer, 0, len(servers))
+	for _, server := range servers {
+		if c.zoneOf(server) == c.Zone {
+			local = append(local, server)
+		}
+	}
+	if len(local) == 0 {
+		return servers
+	}
+	return local
+}
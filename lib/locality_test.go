@@ -0,0 +1,65 @@
+package lib
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	discovery "github.com/scalog/scalog/discovery/rpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestZoneOf(t *testing.T) {
+	config := &config{Localities: []Locality{
+		{Zone: "a", ServerIDs: []int32{1}},
+		{Zone: "b", Address: "10.0.2.*"},
+	}}
+	tests := []struct {
+		server   *discovery.DataServer
+		expected string
+	}{
+		{&discovery.DataServer{ServerID: 1, Ip: "10.0.2.1", Port: 26000}, "a"},
+		{&discovery.DataServer{ServerID: 2, Ip: "10.0.2.2", Port: 26000}, "b"},
+		{&discovery.DataServer{ServerID: 3, Ip: "10.0.3.3", Port: 26000}, ""},
+	}
+	for _, test := range tests {
+		if zone := config.zoneOf(test.server); zone != test.expected {
+			t.Fatalf("Expected: %q, Actual: %q", test.expected, zone)
+		}
+	}
+}
+
+func TestPickServerLocality(t *testing.T) {
+	metrics := NewStandardMetrics()
+	c := &Client{
+		config: &config{Zone: "a", Localities: []Locality{
+			{Zone: "a", ServerIDs: []int32{1}},
+			{Zone: "b", ServerIDs: []int32{2, 3}},
+		}},
+		health:  newHealthTracker(),
+		metrics: metrics,
+	}
+	shard := &discovery.Shard{ShardID: 0, Servers: []*discovery.DataServer{
+		{ServerID: 1, Ip: "127.0.0.1", Port: 26001},
+		{ServerID: 2, Ip: "127.0.0.1", Port: 26002},
+		{ServerID: 3, Ip: "127.0.0.1", Port: 26003},
+	}}
+	for i := 0; i < 10; i++ {
+		if server := c.pickServer(shard, nil); server.ServerID != 1 {
+			t.Fatalf("Expected: %d, Actual: %d", 1, server.ServerID)
+		}
+	}
+	// Fall back to the other zone once the local replica is unhealthy
+	for i := 0; i < defaultFailureThreshold; i++ {
+		c.health.report(0, 1, status.Error(codes.Unavailable, "unavailable"))
+	}
+	if server := c.pickServer(shard, nil); server.ServerID == 1 {
+		t.Fatalf("Expected: %s, Actual: %d", "server in zone b", server.ServerID)
+	}
+	var b bytes.Buffer
+	metrics.WritePrometheus(&b)
+	if expected := `scalog_client_cross_zone_requests_total{zone="b"} 1`; !strings.Contains(b.String(), expected) {
+		t.Fatalf("Expected: %s, Actual: %s", expected, b.String())
+	}
+}
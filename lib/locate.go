@@ -0,0 +1,113 @@
+package lib
+
+import (
+	"context"
+	"sync"
+)
+
+// defaultShardIndexSize is the default maximum number of locations held by the
+// shard index.
+const defaultShardIndexSize = 1 << 16
+
+// shardIndex is a bounded map from global sequence number to the identifier of
+// the shard that holds the record. It is filled by appends, subscriptions and
+// reads, and evicts the oldest locations first.
+type shardIndex struct {
+	// Maximum number of locations held by the index
+	maxEntries int
+	// Map from global sequence number to shard identifier
+	shardIDs map[int32]int32
+	// Global sequence numbers in order of insertion
+	order []int32
+	// Mutex for accessing shardIDs and order
+	mu sync.Mutex
+}
+
+// Read reads a record with a global sequence number without requiring the
+// identifier of the shard that holds it. The owning shard is taken from the
+// locations learned by earlier appends, subscriptions and reads, or found by
+// querying every shard in parallel.
+func (c *Client) Read(gsn int32) (string, error) {
+	if c.isClosed() {
+		return "", &OpError{Op: "Read", ShardID: -1, Gsn: gsn, Kind: ErrClosed}
+	}
+	if shardID, in := c.shardIndex.get(gsn); in {
+		record, err := c.ReadRecord(gsn, shardID)
+		if err == nil {
+			return record, nil
+		}
+		if !isRecordMissing(err) {
+			return "", err
+		}
+		c.shardIndex.remove(gsn)
+	}
+	record, _, err := c.readFromAnyShard(context.Background(), gsn)
+	if err != nil {
+		return "", err
+	}
+	return record, nil
+}
+
+// newShardIndex returns a new instance of shardIndex.
+func newShardIndex(maxEntries int) *shardIndex {
+	return &shardIndex{
+		maxEntries: maxEntries,
+		shardIDs:   make(map[int32]int32),
+	}
+}
+
+// get returns the identifier of the shard that holds the record with a global
+// sequence number, and whether the location is known.
+func (si *shardIndex) get(gsn int32) (int32, bool) {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	shardID, in := si.shardIDs[gsn]
+	return shardID, in
+}
+
+// add records the shard that holds the record with a global sequence number.
+func (si *shardIndex) add(gsn int32, shardID int32) {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	if _, in := si.shardIDs[gsn]; in {
+		si.shardIDs[gsn] = shardID
+		return
+	}
+	si.shardIDs[gsn] = shardID
+	si.order = append(si.order, gsn)
+	for len(si.order) > si.maxEntries {
+		delete(si.shardIDs, si.order[0])
+		si.order = si.order[1:]
+	}
+}
+
+// remove forgets the location of the record with a global sequence number.
+func (si *shardIndex) remove(gsn int32) {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	if _, in := si.shardIDs[gsn]; !in {
+		return
+	}
+	delete(si.shardIDs, gsn)
+	for i, entryGsn := range si.order {
+		if entryGsn == gsn {
+			si.order = append(si.order[:i], si.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// trim forgets the locations of the records before a global sequence number.
+func (si *shardIndex) trim(gsn int32) {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	order := si.order[:0]
+	for _, entryGsn := range si.order {
+		if entryGsn < gsn {
+			delete(si.shardIDs, entryGsn)
+		} else {
+			order = append(order, entryGsn)
+		}
+	}
+	si.order = order
+}
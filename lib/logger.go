@@ -0,0 +1,130 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Level is the severity of a log event.
+type Level int
+
+const (
+	// LevelDebug is the level of detailed events useful when debugging.
+	LevelDebug Level = iota
+	// LevelInfo is the level of routine events such as view changes.
+	LevelInfo
+	// LevelWarn is the level of failures the client recovers from, such as
+	// retries and reconnects.
+	LevelWarn
+	// LevelError is the level of failures the client cannot recover from.
+	LevelError
+)
+
+// Logger receives the client's log events. Each event has a message and
+// alternating keys and values describing it.
+type Logger interface {
+	// Debug logs an event at LevelDebug.
+	Debug(msg string, keyvals ...interface{})
+	// Info logs an event at LevelInfo.
+	Info(msg string, keyvals ...interface{})
+	// Warn logs an event at LevelWarn.
+	Warn(msg string, keyvals ...interface{})
+	// Error logs an event at LevelError.
+	Error(msg string, keyvals ...interface{})
+}
+
+// stdLogger is a Logger that writes events in logfmt to a standard library
+// logger.
+type stdLogger struct {
+	// Logger to which events are written
+	logger *log.Logger
+	// Minimum level of the events written
+	level Level
+}
+
+// nopLogger is a Logger that discards all events.
+type nopLogger struct{}
+
+// WithLogger sets the Logger that receives the client's log events. By default
+// events are discarded.
+func WithLogger(logger Logger) Option {
+	return func(c *Client) error {
+		c.logger = logger
+		return nil
+	}
+}
+
+// NewStdLogger returns a Logger that writes events at or above a level to a
+// standard library logger, formatted as logfmt key/value pairs.
+func NewStdLogger(logger *log.Logger, level Level) Logger {
+	return &stdLogger{logger: logger, level: level}
+}
+
+// String returns the name of a level.
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	}
+	return fmt.Sprintf("Level(%d)", int(l))
+}
+
+// Debug logs an event at LevelDebug.
+func (l *stdLogger) Debug(msg string, keyvals ...interface{}) {
+	l.log(LevelDebug, msg, keyvals)
+}
+
+// Info logs an event at LevelInfo.
+func (l *stdLogger) Info(msg string, keyvals ...interface{}) {
+	l.log(LevelInfo, msg, keyvals)
+}
+
+// Warn logs an event at LevelWarn.
+func (l *stdLogger) Warn(msg string, keyvals ...interface{}) {
+	l.log(LevelWarn, msg, keyvals)
+}
+
+// Error logs an event at LevelError.
+func (l *stdLogger) Error(msg string, keyvals ...interface{}) {
+	l.log(LevelError, msg, keyvals)
+}
+
+// log writes an event if its level is at or above the logger's level.
+func (l *stdLogger) log(level Level, msg string, keyvals []interface{}) {
+	if level < l.level {
+		return
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "level=%s msg=%s", level, formatLogValue(msg))
+	for i := 0; i < len(keyvals); i += 2 {
+		var value interface{} = "MISSING"
+		if i+1 < len(keyvals) {
+			value = keyvals[i+1]
+		}
+		fmt.Fprintf(&buf, " %v=%s", keyvals[i], formatLogValue(value))
+	}
+	l.logger.Println(buf.String())
+}
+
+// formatLogValue formats a value for logfmt, quoting it if it contains spaces,
+// quotes or equal signs.
+func formatLogValue(value interface{}) string {
+	s := fmt.Sprint(value)
+	if s == "" || strings.ContainsAny(s, " \"=") {
+		return fmt.Sprintf("%q", s)
+	}
+	return s
+}
+
+func (nopLogger) Debug(msg string, keyvals ...interface{}) {}
+func (nopLogger) Info(msg string, keyvals ...interface{})  {}
+func (nopLogger) Warn(msg string, keyvals ...interface{})  {}
+func (nopLogger) Error(msg string, keyvals ...interface{}) {}
@@ -0,0 +1,18 @@
+package lib
+
+import (
+	"bytes"
+	"log"
+	"testing"
+)
+
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewStdLogger(log.New(&buf, "", 0), LevelInfo)
+	logger.Debug("Waiting for gap in subscription", "nextGsn", 1)
+	logger.Warn("Subscription stream failed", "shard", 0, "err", "connection reset")
+	expected := "level=warn msg=\"Subscription stream failed\" shard=0 err=\"connection reset\"\n"
+	if buf.String() != expected {
+		t.Fatalf("Expected: %s, Actual: %s", expected, buf.String())
+	}
+}
@@ -0,0 +1,295 @@
+package lib
+
+import (
+	"bufio"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds in seconds of the latency histogram
+// buckets.
+var latencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Metrics receives measurements of a client's activity.
+type Metrics interface {
+	// ObserveAppend records the latency and outcome of an append to a shard.
+	ObserveAppend(shardID int32, latency time.Duration, err error)
+	// ObserveRead records the latency and outcome of a read from a shard.
+	ObserveRead(shardID int32, latency time.Duration, err error)
+	// ObserveViewRefresh records the outcome of a query to the discovery
+	// service.
+	ObserveViewRefresh(err error)
+	// SetSubscriptionLag records the difference between the largest global
+	// sequence number received by subscriptions and the next one to be
+	// delivered.
+	SetSubscriptionLag(lag int64)
+	// SetReorderBufferDepth records the number of records received by
+	// subscriptions that are waiting for earlier records to be delivered.
+	SetReorderBufferDepth(depth int)
+	// ObserveCrossZone records a request to a shard sent to a replica in
+	// another zone than the client's, or in no known zone if zone is empty.
+	ObserveCrossZone(shardID int32, zone string)
+}
+
+// StandardMetrics is a Metrics implementation that keeps latency histograms
+// and counters in memory, and exposes them through expvar and the Prometheus
+// text exposition format.
+type StandardMetrics struct {
+	// Latency histograms and error counters by shard identifier
+	appends map[int32]*shardMetrics
+	reads   map[int32]*shardMetrics
+	// Number of successful and failed queries to the discovery service
+	viewRefreshes      int64
+	viewRefreshErrors  int64
+	subscriptionLag    int64
+	reorderBufferDepth int64
+	// Number of requests sent to replicas in other zones by zone
+	crossZone map[string]int64
+	// Mutex for accessing all metrics
+	mu sync.Mutex
+}
+
+// shardMetrics contains the latency histogram and error counter of one kind
+// of request to a shard.
+type shardMetrics struct {
+	// Number of observations in each bucket of latencyBuckets, followed by
+	// the number of observations above the largest bucket
+	buckets []int64
+	// Sum in seconds of all observed latencies
+	sum float64
+	// Number of observations
+	count int64
+	// Number of failed requests
+	errors int64
+}
+
+// noopMetrics is a Metrics implementation that discards all measurements.
+type noopMetrics struct{}
+
+// WithMetrics sets the Metrics that receive measurements of the client's
+// activity. By default measurements are discarded.
+func WithMetrics(metrics Metrics) Option {
+	return func(c *Client) error {
+		c.metrics = metrics
+		return nil
+	}
+}
+
+// NewStandardMetrics returns a new instance of StandardMetrics.
+func NewStandardMetrics() *StandardMetrics {
+	return &StandardMetrics{
+		appends:   make(map[int32]*shardMetrics),
+		reads:     make(map[int32]*shardMetrics),
+		crossZone: make(map[string]int64),
+	}
+}
+
+// ObserveAppend records the latency and outcome of an append to a shard.
+func (m *StandardMetrics) ObserveAppend(shardID int32, latency time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	observe(m.appends, shardID, latency, err)
+}
+
+// ObserveRead records the latency and outcome of a read from a shard.
+func (m *StandardMetrics) ObserveRead(shardID int32, latency time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	observe(m.reads, shardID, latency, err)
+}
+
+// ObserveViewRefresh records the outcome of a query to the discovery service.
+func (m *StandardMetrics) ObserveViewRefresh(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.viewRefreshes++
+	if err != nil {
+		m.viewRefreshErrors++
+	}
+}
+
+// SetSubscriptionLag records the lag of subscriptions.
+func (m *StandardMetrics) SetSubscriptionLag(lag int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.subscriptionLag = lag
+}
+
+// SetReorderBufferDepth records the depth of the subscription reorder buffer.
+func (m *StandardMetrics) SetReorderBufferDepth(depth int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reorderBufferDepth = int64(depth)
+}
+
+// ObserveCrossZone records a request sent to a replica in another zone.
+func (m *StandardMetrics) ObserveCrossZone(shardID int32, zone string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.crossZone[zone]++
+}
+
+// PublishExpvar publishes the metrics as an expvar variable with a name, so
+// that they are served in JSON at /debug/vars.
+func (m *StandardMetrics) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(m.snapshot))
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (m *StandardMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.WritePrometheus(w)
+}
+
+// WritePrometheus writes the metrics to w in the Prometheus text exposition
+// format.
+func (m *StandardMetrics) WritePrometheus(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	bw := bufio.NewWriter(w)
+	writeShardMetrics(bw, "scalog_client_append", "appends", m.appends)
+	writeShardMetrics(bw, "scalog_client_read", "reads", m.reads)
+	fmt.Fprintln(bw, "# HELP scalog_client_view_refreshes_total Queries to the discovery service.")
+	fmt.Fprintln(bw, "# TYPE scalog_client_view_refreshes_total counter")
+	fmt.Fprintf(bw, "scalog_client_view_refreshes_total %d\n", m.viewRefreshes)
+	fmt.Fprintln(bw, "# HELP scalog_client_view_refresh_errors_total Failed queries to the discovery service.")
+	fmt.Fprintln(bw, "# TYPE scalog_client_view_refresh_errors_total counter")
+	fmt.Fprintf(bw, "scalog_client_view_refresh_errors_total %d\n", m.viewRefreshErrors)
+	fmt.Fprintln(bw, "# HELP scalog_client_subscription_lag Records received by subscriptions but not yet delivered.")
+	fmt.Fprintln(bw, "# TYPE scalog_client_subscription_lag gauge")
+	fmt.Fprintf(bw, "scalog_client_subscription_lag %d\n", m.subscriptionLag)
+	fmt.Fprintln(bw, "# HELP scalog_client_reorder_buffer_depth Records waiting for earlier records to be delivered.")
+	fmt.Fprintln(bw, "# TYPE scalog_client_reorder_buffer_depth gauge")
+	fmt.Fprintf(bw, "scalog_client_reorder_buffer_depth %d\n", m.reorderBufferDepth)
+	fmt.Fprintln(bw, "# HELP scalog_client_cross_zone_requests_total Requests sent to replicas in other zones by zone.")
+	fmt.Fprintln(bw, "# TYPE scalog_client_cross_zone_requests_total counter")
+	for _, zone := range sortedZones(m.crossZone) {
+		fmt.Fprintf(bw, "scalog_client_cross_zone_requests_total{zone=%q} %d\n", zone, m.crossZone[zone])
+	}
+	return bw.Flush()
+}
+
+// snapshot returns the metrics as a value that can be encoded in JSON.
+func (m *StandardMetrics) snapshot() interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return map[string]interface{}{
+		"appends":              snapshotShardMetrics(m.appends),
+		"reads":                snapshotShardMetrics(m.reads),
+		"view_refreshes":       m.viewRefreshes,
+		"view_refresh_errors":  m.viewRefreshErrors,
+		"subscription_lag":     m.subscriptionLag,
+		"reorder_buffer_depth": m.reorderBufferDepth,
+		"cross_zone_requests":  copyCounts(m.crossZone),
+	}
+}
+
+// observe records the latency and outcome of a request to a shard.
+func observe(metrics map[int32]*shardMetrics, shardID int32, latency time.Duration, err error) {
+	sm, in := metrics[shardID]
+	if !in {
+		sm = &shardMetrics{buckets: make([]int64, len(latencyBuckets)+1)}
+		metrics[shardID] = sm
+	}
+	seconds := latency.Seconds()
+	sm.buckets[sort.SearchFloat64s(latencyBuckets, seconds)]++
+	sm.sum += seconds
+	sm.count++
+	if err != nil {
+		sm.errors++
+	}
+}
+
+// writeShardMetrics writes the latency histograms and error counters of one
+// kind of request in the Prometheus text exposition format.
+func writeShardMetrics(w io.Writer, name string, help string, metrics map[int32]*shardMetrics) {
+	shardIDs := sortedShardIDs(metrics)
+	fmt.Fprintf(w, "# HELP %s_latency_seconds Latency of %s by shard.\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s_latency_seconds histogram\n", name)
+	for _, shardID := range shardIDs {
+		sm := metrics[shardID]
+		cumulative := int64(0)
+		for i, bucket := range sm.buckets {
+			cumulative += bucket
+			le := "+Inf"
+			if i < len(latencyBuckets) {
+				le = strconv.FormatFloat(latencyBuckets[i], 'g', -1, 64)
+			}
+			fmt.Fprintf(w, "%s_latency_seconds_bucket{shard=\"%d\",le=\"%s\"} %d\n", name, shardID, le, cumulative)
+		}
+		fmt.Fprintf(w, "%s_latency_seconds_sum{shard=\"%d\"} %g\n", name, shardID, sm.sum)
+		fmt.Fprintf(w, "%s_latency_seconds_count{shard=\"%d\"} %d\n", name, shardID, sm.count)
+	}
+	fmt.Fprintf(w, "# HELP %s_errors_total Failed %s by shard.\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s_errors_total counter\n", name)
+	for _, shardID := range shardIDs {
+		fmt.Fprintf(w, "%s_errors_total{shard=\"%d\"} %d\n", name, shardID, metrics[shardID].errors)
+	}
+}
+
+// snapshotShardMetrics returns the metrics of one kind of request as a value
+// that can be encoded in JSON.
+func snapshotShardMetrics(metrics map[int32]*shardMetrics) map[string]interface{} {
+	snapshot := make(map[string]interface{}, len(metrics))
+	for shardID, sm := range metrics {
+		buckets := make(map[string]int64, len(sm.buckets))
+		for i, bucket := range sm.buckets {
+			le := "+Inf"
+			if i < len(latencyBuckets) {
+				le = strconv.FormatFloat(latencyBuckets[i], 'g', -1, 64)
+			}
+			buckets[le] = bucket
+		}
+		snapshot[strconv.Itoa(int(shardID))] = map[string]interface{}{
+			"latency_buckets":     buckets,
+			"latency_sum_seconds": sm.sum,
+			"count":               sm.count,
+			"errors":              sm.errors,
+		}
+	}
+	return snapshot
+}
+
+// sortedShardIDs returns the shard identifiers of metrics in increasing order.
+func sortedShardIDs(metrics map[int32]*shardMetrics) []int32 {
+	shardIDs := make([]int32, 0, len(metrics))
+	for shardID := range metrics {
+		shardIDs = append(shardIDs, shardID)
+	}
+	sort.Slice(shardIDs, func(i, j int) bool {
+		return shardIDs[i] < shardIDs[j]
+	})
+	return shardIDs
+}
+
+// sortedZones returns the zones of cross-zone counters in increasing order.
+func sortedZones(counts map[string]int64) []string {
+	zones := make([]string, 0, len(counts))
+	for zone := range counts {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+	return zones
+}
+
+// copyCounts returns a copy of counters.
+func copyCounts(counts map[string]int64) map[string]int64 {
+	copied := make(map[string]int64, len(counts))
+	for key, count := range counts {
+		copied[key] = count
+	}
+	return copied
+}
+
+func (noopMetrics) ObserveAppend(shardID int32, latency time.Duration, err error) {}
+func (noopMetrics) ObserveRead(shardID int32, latency time.Duration, err error)   {}
+func (noopMetrics) ObserveViewRefresh(err error)                                  {}
+func (noopMetrics) SetSubscriptionLag(lag int64)                                  {}
+func (noopMetrics) SetReorderBufferDepth(depth int)                               {}
+func (noopMetrics) ObserveCrossZone(shardID int32, zone string)                   {}
@@ -0,0 +1,35 @@
+package lib
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStandardMetricsPrometheus(t *testing.T) {
+	metrics := NewStandardMetrics()
+	metrics.ObserveAppend(0, 3*time.Millisecond, nil)
+	metrics.ObserveAppend(0, 20*time.Second, errors.New("unavailable"))
+	metrics.ObserveViewRefresh(nil)
+	metrics.SetReorderBufferDepth(4)
+	var buf bytes.Buffer
+	err := metrics.WritePrometheus(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`scalog_client_append_latency_seconds_bucket{shard="0",le="0.0025"} 0`,
+		`scalog_client_append_latency_seconds_bucket{shard="0",le="0.005"} 1`,
+		`scalog_client_append_latency_seconds_bucket{shard="0",le="+Inf"} 2`,
+		`scalog_client_append_latency_seconds_count{shard="0"} 2`,
+		`scalog_client_append_errors_total{shard="0"} 1`,
+		`scalog_client_view_refreshes_total 1`,
+		`scalog_client_reorder_buffer_depth 4`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Fatalf("Expected exposition to contain %q, Actual:\n%s", line, buf.String())
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package lib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+// pendingRecord represents a record that has been assigned a client sequence
+// number but has not yet been acknowledged by Scalog.
+type pendingRecord struct {
+	// Client sequence number assigned to the record
+	Csn int32 `yaml:"csn"`
+	// Identifier of the shard the record was appended to
+	ShardID int32 `yaml:"shard-id"`
+	// Data of record
+	Record string `yaml:"record"`
+}
+
+// producerState is the durable state of an exactly-once producer. It is kept
+// in a local file so that a restarted producer resumes the same client
+// identifier and client sequence numbers, and can resend records that were in
+// flight when it stopped.
+type producerState struct {
+	// Path of the file in which the state is persisted
+	path string
+	// Mutex for accessing the state
+	mu sync.Mutex
+	// Mutex serializing writes of the state to its file
+	saveMu sync.Mutex
+	// Number of changes made to the state
+	version uint64
+	// Number of changes made to the state when it was last written
+	savedVersion uint64
+	// Unique client identifier
+	ClientID int32 `yaml:"client-id"`
+	// Client sequence number to be assigned to the next record
+	NextCsn int32 `yaml:"next-csn"`
+	// Client sequence number that was to be assigned next when the state was
+	// loaded. Pending records below it were left by a previous process.
+	resumedCsn int32
+	// Records that have not been acknowledged, in order of client sequence
+	// number
+	Pending []pendingRecord `yaml:"pending"`
+}
+
+// WithProducerState makes the client an exactly-once producer whose client
+// identifier and client sequence numbers are persisted in the file at path.
+// If the file does not exist it is created with a newly assigned client
+// identifier. Records that were not acknowledged before the producer stopped
+// can be resent with ResendPending.
+//
+// Every append rewrites and syncs the whole file twice, once before the record
+// is sent and once after it is acknowledged, so appends are bounded by the
+// latency of disk syncs and slow down as more records are pending. Concurrent
+// appends share writes of the file.
+func WithProducerState(path string) Option {
+	return func(c *Client) error {
+		state, err := loadProducerState(path)
+		if err != nil {
+			return err
+		}
+		c.clientID = state.ClientID
+		c.nextCsn = state.NextCsn
+		c.producer = state
+		return nil
+	}
+}
+
+// ResendPending resends the records that were not acknowledged before the
+// producer last stopped, using their original client sequence numbers so that
+// Scalog can deduplicate them, and returns the committed records. Records are
+// resent to their original shard if it is still in the view. Records appended
+// by this process are not resent, even if they are still in flight.
+// ResendPending returns an error if the client was not created with
+// WithProducerState.
+func (c *Client) ResendPending() ([]CommittedRecord, error) {
+	if c.producer == nil {
+		return nil, fmt.Errorf("Attempted to resend pending records without producer state")
+	}
+	pending := c.producer.leftover()
+	committedRecords := make([]CommittedRecord, 0, len(pending))
+	for _, p := range pending {
+		shard := c.getShard(p.ShardID)
+		if shard == nil {
+			view := c.getView()
+			if len(view) == 0 {
+				return committedRecords, &OpError{Op: "Append", ShardID: p.ShardID, Gsn: -1, Kind: ErrShardNotFound}
+			}
+			c.configMu.RLock()
+			shard = c.shardPolicy(view, p.Record)
+			c.configMu.RUnlock()
+		}
+		gsn, err := c.appendToShard(shard, p.Csn, p.Record)
+		if err != nil {
+			return committedRecords, err
+		}
+		err = c.producer.ack(p.Csn)
+		if err != nil {
+			return committedRecords, err
+		}
+		committedRecords = append(committedRecords, CommittedRecord{
+			Gsn:    gsn,
+			Record: p.Record,
+		})
+	}
+	return committedRecords, nil
+}
+
+// loadProducerState reads the producer state persisted at path, or creates and
+// persists a new state if no file exists.
+func loadProducerState(path string) (*producerState, error) {
+	state := &producerState{path: path}
+	file, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		state.ClientID = assignClientID()
+		state.NextCsn = 0
+		return state, state.save()
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = yaml.Unmarshal(file, state)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(state.Pending, func(i, j int) bool {
+		return state.Pending[i].Csn < state.Pending[j].Csn
+	})
+	state.resumedCsn = state.NextCsn
+	return state, nil
+}
+
+// begin records that a record has been assigned a client sequence number and
+// is about to be sent, and returns once the record is persisted.
+func (s *producerState) begin(csn int32, shardID int32, record string) error {
+	s.mu.Lock()
+	i := sort.Search(len(s.Pending), func(i int) bool {
+		return s.Pending[i].Csn > csn
+	})
+	s.Pending = append(s.Pending, pendingRecord{})
+	copy(s.Pending[i+1:], s.Pending[i:])
+	s.Pending[i] = pendingRecord{
+		Csn:     csn,
+		ShardID: shardID,
+		Record:  record,
+	}
+	if csn >= s.NextCsn {
+		s.NextCsn = csn + 1
+	}
+	s.version++
+	version := s.version
+	s.mu.Unlock()
+	return s.persist(version)
+}
+
+// ack records that the record with a client sequence number has been
+// acknowledged by Scalog, and returns once the acknowledgement is persisted.
+func (s *producerState) ack(csn int32) error {
+	s.mu.Lock()
+	for i, p := range s.Pending {
+		if p.Csn == csn {
+			s.Pending = append(s.Pending[:i], s.Pending[i+1:]...)
+			break
+		}
+	}
+	s.version++
+	version := s.version
+	s.mu.Unlock()
+	return s.persist(version)
+}
+
+// persist writes the state to its file unless a write that included a version
+// of the state has already completed. Changes made while a write is in
+// progress are persisted together by the next write.
+func (s *producerState) persist(version uint64) error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	s.mu.Lock()
+	if s.savedVersion >= version {
+		s.mu.Unlock()
+		return nil
+	}
+	snapshot := &producerState{
+		ClientID: s.ClientID,
+		NextCsn:  s.NextCsn,
+		Pending:  append([]pendingRecord(nil), s.Pending...),
+	}
+	current := s.version
+	s.mu.Unlock()
+	out, err := yaml.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomic(s.path, out)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.savedVersion = current
+	s.mu.Unlock()
+	return nil
+}
+
+// leftover returns a copy of the records that a previous process left
+// unacknowledged.
+func (s *producerState) leftover() []pendingRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	leftover := make([]pendingRecord, 0, len(s.Pending))
+	for _, p := range s.Pending {
+		if p.Csn < s.resumedCsn {
+			leftover = append(leftover, p)
+		}
+	}
+	return leftover
+}
+
+// save atomically writes the state to its file. The caller must hold mu.
+func (s *producerState) save() error {
+	out, err := yaml.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, out)
+}
+
+// writeFileAtomic writes data to the file at path by renaming a synced
+// temporary file, so that readers never see a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
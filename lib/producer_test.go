@@ -0,0 +1,179 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestProducerStateResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "producer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "producer.yaml")
+	state, err := loadProducerState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for csn := int32(0); csn < 3; csn++ {
+		err = state.begin(csn, 1, "Hello, World!")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = state.ack(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resumed, err := loadProducerState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed.ClientID != state.ClientID {
+		t.Fatalf("Expected: %d, Actual: %d", state.ClientID, resumed.ClientID)
+	}
+	if resumed.NextCsn != 3 {
+		t.Fatalf("Expected: %d, Actual: %d", 3, resumed.NextCsn)
+	}
+	pending := resumed.leftover()
+	if len(pending) != 2 || pending[0].Csn != 1 || pending[1].Csn != 2 {
+		t.Fatalf("Expected pending records 1 and 2, Actual: %v", pending)
+	}
+}
+
+func TestProducerStateConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "producer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "producer.yaml")
+	state, err := loadProducerState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for csn := int32(0); csn < 16; csn++ {
+		wg.Add(1)
+		go func(csn int32) {
+			defer wg.Done()
+			if err := state.begin(csn, 0, fmt.Sprintf("Record %d", csn)); err != nil {
+				t.Error(err)
+			}
+			if csn%2 == 0 {
+				if err := state.ack(csn); err != nil {
+					t.Error(err)
+				}
+			}
+		}(csn)
+	}
+	wg.Wait()
+	resumed, err := loadProducerState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed.NextCsn != 16 {
+		t.Fatalf("Expected: %d, Actual: %d", 16, resumed.NextCsn)
+	}
+	pending := resumed.leftover()
+	if len(pending) != 8 {
+		t.Fatalf("Expected: %d, Actual: %d", 8, len(pending))
+	}
+	for i, p := range pending {
+		if p.Csn != int32(2*i+1) {
+			t.Fatalf("Expected: %d, Actual: %d", 2*i+1, p.Csn)
+		}
+	}
+}
+
+func TestResendPending(t *testing.T) {
+	cluster := newTestCluster(t)
+	defer cluster.Close()
+	dir, err := ioutil.TempDir("", "producer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "producer.yaml")
+	first := newTestClient(t, cluster, WithProducerState(path))
+	if _, err := first.Append("Record 0"); err != nil {
+		t.Fatal(err)
+	}
+	// Record 1 reaches Scalog but its acknowledgement is lost, and record 2
+	// is never sent
+	shard := first.view[0]
+	if err := first.producer.begin(1, shard.ShardID, "Record 1"); err != nil {
+		t.Fatal(err)
+	}
+	gsn, err := first.appendToShard(shard, 1, "Record 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first.producer.begin(2, shard.ShardID, "Record 2"); err != nil {
+		t.Fatal(err)
+	}
+	first.Close()
+
+	second := newTestClient(t, cluster, WithProducerState(path))
+	defer second.Close()
+	// Appends in flight in this process are not resent
+	if err := second.producer.begin(3, shard.ShardID, "Record 3"); err != nil {
+		t.Fatal(err)
+	}
+	committedRecords, err := second.ResendPending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(committedRecords) != 2 {
+		t.Fatalf("Expected: %d, Actual: %d", 2, len(committedRecords))
+	}
+	if committedRecords[0].Gsn != gsn {
+		t.Fatalf("Expected: %d, Actual: %d", gsn, committedRecords[0].Gsn)
+	}
+	records := cluster.Records()
+	if len(records) != 3 {
+		t.Fatalf("Expected: %d, Actual: %d", 3, len(records))
+	}
+	for i, committedRecord := range committedRecords {
+		if expected := fmt.Sprintf("Record %d", i+1); records[committedRecord.Gsn] != expected {
+			t.Fatalf("Expected: %s, Actual: %s", expected, records[committedRecord.Gsn])
+		}
+	}
+	if leftover := second.producer.leftover(); len(leftover) != 0 {
+		t.Fatalf("Expected no leftover records, Actual: %v", leftover)
+	}
+}
+
+func TestResendPendingEmptyView(t *testing.T) {
+	cluster := newTestCluster(t)
+	defer cluster.Close()
+	dir, err := ioutil.TempDir("", "producer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "producer.yaml")
+	state, err := loadProducerState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Record 0 was appended to a shard that is no longer in the view
+	if err := state.begin(0, 7, "Record 0"); err != nil {
+		t.Fatal(err)
+	}
+	client := newTestClient(t, cluster, WithProducerState(path))
+	defer client.Close()
+	client.viewMu.Lock()
+	client.view = nil
+	client.viewMu.Unlock()
+	_, err = client.ResendPending()
+	if !errors.Is(err, ErrShardNotFound) {
+		t.Fatalf("Expected: %v, Actual: %v", ErrShardNotFound, err)
+	}
+}
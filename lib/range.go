@@ -0,0 +1,174 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	discovery "github.com/scalog/scalog/discovery/rpc"
+)
+
+// defaultReadParallelism is the default number of records fetched
+// concurrently by ReadRange.
+const defaultReadParallelism = 8
+
+// RecordIterator iterates over the records read by ReadRange in order of
+// global sequence number.
+type RecordIterator struct {
+	// Channel of pending reads in order of global sequence number
+	reads chan chan rangeRead
+	// Most recent record returned by Next
+	record CommittedRecord
+	// Error that stopped the iteration, if any
+	err error
+	// Function that stops fetching records
+	cancel context.CancelFunc
+}
+
+// rangeRead represents the result of reading a single record of a range.
+type rangeRead struct {
+	committedRecord CommittedRecord
+	err             error
+}
+
+// WithReadParallelism sets the maximum number of records fetched concurrently
+// by ReadRange.
+func WithReadParallelism(parallelism int) Option {
+	return func(c *Client) error {
+		if parallelism < 1 {
+			return fmt.Errorf("Read parallelism must be greater than 0")
+		}
+		c.readParallelism = parallelism
+		return nil
+	}
+}
+
+// ReadRange reads the records with global sequence numbers in [from, to) from
+// whichever shards hold them, and returns an iterator over the records in
+// order of global sequence number. The iteration stops cleanly before the
+// first record that has not been committed yet, and stops with
+// ErrRecordTrimmed at a record that has been trimmed.
+func (c *Client) ReadRange(from int32, to int32) *RecordIterator {
+	ctx, cancel := context.WithCancel(context.Background())
+	it := &RecordIterator{
+		reads:  make(chan chan rangeRead, c.readParallelism),
+		cancel: cancel,
+	}
+	if c.isClosed() {
+		it.err = &OpError{Op: "Read", ShardID: -1, Gsn: from, Kind: ErrClosed}
+		close(it.reads)
+		return it
+	}
+	go func() {
+		defer close(it.reads)
+		for gsn := from; gsn < to; gsn++ {
+			read := make(chan rangeRead, 1)
+			select {
+			case it.reads <- read:
+			case <-ctx.Done():
+				return
+			}
+			go func(gsn int32) {
+				record, _, err := c.readFromAnyShard(ctx, gsn)
+				read <- rangeRead{
+					committedRecord: CommittedRecord{Gsn: gsn, Record: record},
+					err:             err,
+				}
+			}(gsn)
+		}
+	}()
+	return it
+}
+
+// Next advances the iterator to the next record, which is then available
+// through Record. It returns false when the iteration stops, either at the end
+// of the range or because of an error reported by Err.
+func (it *RecordIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	read, ok := <-it.reads
+	if !ok {
+		return false
+	}
+	result := <-read
+	if result.err != nil {
+		if !isRecordMissing(result.err) || errors.Is(result.err, ErrRecordTrimmed) {
+			it.err = result.err
+		}
+		it.Close()
+		return false
+	}
+	it.record = result.committedRecord
+	return true
+}
+
+// Record returns the record the iterator is positioned at.
+func (it *RecordIterator) Record() CommittedRecord {
+	return it.record
+}
+
+// Err returns the error that stopped the iteration, or nil if the iteration
+// reached the end of the range or an uncommitted record.
+func (it *RecordIterator) Err() error {
+	return it.err
+}
+
+// Close stops fetching records. Close must be called if the iteration is
+// abandoned before Next returns false.
+func (it *RecordIterator) Close() {
+	it.cancel()
+}
+
+// readFromAnyShard reads a record with a global sequence number by querying
+// every shard in parallel, and returns the record and the identifier of the
+// shard that holds it.
+func (c *Client) readFromAnyShard(ctx context.Context, gsn int32) (string, int32, error) {
+	if c.cache != nil {
+		record, shardID, in := c.cache.get(gsn, -1)
+		if in {
+			return record, shardID, nil
+		}
+	}
+	shards := c.getView()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	reads := make(chan shardRead, len(shards))
+	for _, shard := range shards {
+		go func(shard *discovery.Shard) {
+			record, err := c.readFromShard(ctx, shard, gsn)
+			reads <- shardRead{record: record, shardID: shard.ShardID, err: err}
+		}(shard)
+	}
+	var err error = &OpError{Op: "Read", ShardID: -1, Gsn: gsn, Kind: ErrShardNotFound}
+	if len(shards) > 0 {
+		err = &OpError{Op: "Read", ShardID: -1, Gsn: gsn, Kind: ErrNotCommitted}
+	}
+	trimmed := false
+	for range shards {
+		read := <-reads
+		if read.err == nil {
+			if c.cache != nil {
+				c.cache.add(gsn, read.shardID, read.record)
+			}
+			c.shardIndex.add(gsn, read.shardID)
+			return read.record, read.shardID, nil
+		}
+		if !isRecordMissing(read.err) {
+			err = read.err
+		} else if errors.Is(read.err, ErrRecordTrimmed) {
+			trimmed = true
+		}
+	}
+	if trimmed && isRecordMissing(err) {
+		err = &OpError{Op: "Read", ShardID: -1, Gsn: gsn, Kind: ErrRecordTrimmed}
+	}
+	return "", -1, err
+}
+
+// shardRead represents the result of reading a record from a shard.
+type shardRead struct {
+	record  string
+	shardID int32
+	err     error
+}
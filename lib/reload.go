@@ -0,0 +1,203 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// configReloadDelay is the delay between a change to a watched file and the
+// reload, so that a burst of writes results in a single reload.
+const configReloadDelay = 100 * time.Millisecond
+
+// WithConfigWatch watches the configuration file at path, or ./config.yaml if
+// path is empty, along with the TLS files it refers to, and reloads the
+// client's configuration whenever they change. report, if not nil, is called
+// with the outcome of every reload. Like WithViper, WithConfigWatch replaces
+// the settings given with options before it, and reloads replace the settings
+// given with options after it.
+func WithConfigWatch(path string, report func(err error)) Option {
+	return func(c *Client) error {
+		config, err := parseConfig(path)
+		if err != nil {
+			return err
+		}
+		c.config = config
+		c.configPath = path
+		c.watchConfig = true
+		c.reportReload = report
+		return nil
+	}
+}
+
+// ReloadConfig reads the configuration file and environment variables again
+// and applies them to the client. The new configuration is validated and the
+// discovery service is queried with it before it replaces the current one, so
+// that the client keeps its current configuration if ReloadConfig fails.
+func (c *Client) ReloadConfig() error {
+	config, err := parseConfig(c.configPath)
+	if err == nil {
+		err = c.applyConfig(config)
+	}
+	if err != nil {
+		c.logger.Error("Failed to reload configuration", "err", err)
+	} else {
+		c.logger.Info("Reloaded configuration", "discovery", strings.Join(config.discoveryEndpoints(), ","))
+	}
+	if c.reportReload != nil {
+		c.reportReload(err)
+	}
+	return err
+}
+
+// getConfig returns the client's current configuration.
+func (c *Client) getConfig() *config {
+	c.configMu.RLock()
+	defer c.configMu.RUnlock()
+	return c.config
+}
+
+// getTLSConfig returns the client's current TLS configuration, or nil if
+// connections are insecure.
+func (c *Client) getTLSConfig() *tls.Config {
+	c.configMu.RLock()
+	defer c.configMu.RUnlock()
+	return c.tlsConfig
+}
+
+// applyConfig atomically replaces the client's configuration and view with a
+// new configuration and the view of the discovery service it refers to.
+func (c *Client) applyConfig(config *config) error {
+	err := config.validate()
+	if err != nil {
+		return err
+	}
+	c.configMu.RLock()
+	tlsConfig, tlsFromConfig := c.tlsConfig, c.tlsFromConfig
+	c.configMu.RUnlock()
+	if config.TLS != nil {
+		tlsConfig, err = config.TLS.build()
+		if err != nil {
+			return err
+		}
+		tlsFromConfig = true
+	} else if tlsFromConfig {
+		tlsConfig, tlsFromConfig = nil, false
+	}
+	view, err := c.discover(config, tlsConfig)
+	if err != nil {
+		return err
+	}
+	c.viewMu.Lock()
+	c.configMu.Lock()
+	// Balanced shard connections are dialed again if their dial options change
+	redial := c.balanced && (tlsConfig != c.tlsConfig || config.RequestTimeout != c.config.RequestTimeout)
+	c.config = config
+	c.tlsConfig, c.tlsFromConfig = tlsConfig, tlsFromConfig
+	if config.ShardPolicy != "" {
+		c.shardPolicy = shardPolicies[config.ShardPolicy]
+	}
+	c.configMu.Unlock()
+	c.setView(view)
+	c.viewID = unknownViewID
+	c.saveViewCache(config)
+	c.viewMu.Unlock()
+	if redial {
+		c.closeShardConns()
+	}
+	return nil
+}
+
+// startConfigWatch starts watching the configuration file and the TLS files it
+// refers to.
+func (c *Client) startConfigWatch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	paths := c.watchedFiles()
+	for _, path := range paths {
+		err = watcher.Add(filepath.Dir(path))
+		if err != nil {
+			watcher.Close()
+			return err
+		}
+	}
+	go c.watch(watcher, snapshotFiles(paths))
+	return nil
+}
+
+// watch reloads the configuration whenever the contents of the watched files
+// change, until the client is closed. Directories rather than files are
+// watched, so that files replaced by renames or symlink swaps are detected.
+func (c *Client) watch(watcher *fsnotify.Watcher, snapshot []byte) {
+	defer watcher.Close()
+	var reload <-chan time.Time
+	for {
+		select {
+		case <-c.done:
+			return
+		case _, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			reload = time.After(configReloadDelay)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			c.logger.Warn("Failed to watch configuration", "err", err)
+		case <-reload:
+			reload = nil
+			paths := c.watchedFiles()
+			current := snapshotFiles(paths)
+			if bytes.Equal(current, snapshot) {
+				continue
+			}
+			snapshot = current
+			if c.ReloadConfig() == nil {
+				paths = c.watchedFiles()
+				for _, path := range paths {
+					watcher.Add(filepath.Dir(path))
+				}
+				snapshot = snapshotFiles(paths)
+			}
+		}
+	}
+}
+
+// watchedFiles returns the paths of the configuration file and the TLS files
+// it refers to.
+func (c *Client) watchedFiles() []string {
+	path := c.configPath
+	if path == "" {
+		path = defaultConfigFile
+	}
+	paths := []string{path}
+	config := c.getConfig()
+	if config.TLS != nil {
+		for _, path := range []string{config.TLS.CAFile, config.TLS.CertFile, config.TLS.KeyFile} {
+			if path != "" {
+				paths = append(paths, path)
+			}
+		}
+	}
+	return paths
+}
+
+// snapshotFiles returns the concatenated contents of files, skipping files
+// that cannot be read.
+func snapshotFiles(paths []string) []byte {
+	var snapshot []byte
+	for _, path := range paths {
+		b, _ := ioutil.ReadFile(path)
+		snapshot = append(snapshot, b...)
+		snapshot = append(snapshot, 0)
+	}
+	return snapshot
+}
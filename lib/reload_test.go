@@ -0,0 +1,126 @@
+package lib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeDiscoveryConfig writes a configuration file with a discovery address and
+// extra settings.
+func writeDiscoveryConfig(t *testing.T, path string, ip string, port int32, extra string) {
+	contents := fmt.Sprintf("discovery-address:\n  ip: %q\n  port: %d\n%s", ip, port, extra)
+	// Write to a temporary file and rename it, as editors and config managers
+	// do, so that the watcher never reads a partially written file
+	err := ioutil.WriteFile(path+".tmp", []byte(contents), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Rename(path+".tmp", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// awaitReload returns the outcome of the next reload.
+func awaitReload(t *testing.T, reloads chan error) error {
+	select {
+	case err := <-reloads:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for configuration reload")
+		return nil
+	}
+}
+
+func TestConfigWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	first := newTestCluster(t)
+	defer first.Close()
+	second := newTestCluster(t)
+	defer second.Close()
+
+	path := filepath.Join(dir, "config.yaml")
+	ip, port := first.DiscoveryAddress()
+	writeDiscoveryConfig(t, path, ip, port, "")
+	reloads := make(chan error, 1)
+	client, err := NewClient(WithConfigWatch(path, func(err error) {
+		reloads <- err
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := client.Append("first"); err != nil {
+		t.Fatal(err)
+	}
+
+	ip, port = second.DiscoveryAddress()
+	writeDiscoveryConfig(t, path, ip, port, "shard-policy: hash\nrequest-timeout: 5s\n")
+	if err := awaitReload(t, reloads); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Append("second"); err != nil {
+		t.Fatal(err)
+	}
+	if len(first.Records()) != 1 || len(second.Records()) != 1 {
+		t.Fatalf("Expected: %d, Actual: %d", 1, len(second.Records()))
+	}
+	if timeout := client.getConfig().RequestTimeout; timeout != 5*time.Second {
+		t.Fatalf("Expected: %v, Actual: %v", 5*time.Second, timeout)
+	}
+
+	writeDiscoveryConfig(t, path, ip, port, "shard-policy: unknown\n")
+	if err := awaitReload(t, reloads); err == nil {
+		t.Fatalf("Expected reload with unknown shard policy to fail")
+	}
+	if _, err := client.Append("third"); err != nil {
+		t.Fatal(err)
+	}
+	if len(second.Records()) != 2 {
+		t.Fatalf("Expected: %d, Actual: %d", 2, len(second.Records()))
+	}
+}
+
+func TestApplyConfigConcurrentOperations(t *testing.T) {
+	cluster := newTestCluster(t)
+	defer cluster.Close()
+	client := newTestClient(t, cluster)
+	defer client.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			if err := client.applyConfig(client.getConfig()); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		gsn, shardID, err := client.AppendToShard(fmt.Sprintf("Record %d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.ReadRecord(gsn, shardID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+	// The version of the reloaded view is learned from the next response
+	if _, err := client.Append("Hello, World!"); err != nil {
+		t.Fatal(err)
+	}
+	client.viewMu.RLock()
+	defer client.viewMu.RUnlock()
+	if client.viewID != cluster.ViewID() {
+		t.Fatalf("Expected: %d, Actual: %d", cluster.ViewID(), client.viewID)
+	}
+}
@@ -0,0 +1,290 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	discovery "github.com/scalog/scalog/discovery/rpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/resolver"
+)
+
+const (
+	// ResolverScheme is the scheme of gRPC targets resolved to the data
+	// servers of a shard, such as scalog://10.0.0.10:8000/shard/0.
+	ResolverScheme = "scalog"
+	// defaultResolverRefresh is the interval at which resolvers registered by
+	// default query the discovery service.
+	defaultResolverRefresh = 10 * time.Second
+	// shardEndpointPrefix is the prefix of the endpoint of a shard target.
+	shardEndpointPrefix = "shard/"
+)
+
+// serviceConfig selects the replica balancer for connections to shards.
+var serviceConfig = fmt.Sprintf(`{"loadBalancingPolicy":%q}`, BalancerName)
+
+var (
+	// Map from authority to the publisher of the view of the client that
+	// registered the authority
+	viewPublishers = make(map[string]*viewPublisher)
+	// Mutex for accessing viewPublishers
+	viewPublishersMu sync.Mutex
+	// Number of authorities registered by clients, used to name the next one
+	publisherCount int64
+)
+
+func init() {
+	resolver.Register(NewResolverBuilder(defaultResolverRefresh, grpc.WithInsecure()))
+}
+
+// resolverBuilder builds resolvers of shard targets.
+type resolverBuilder struct {
+	// Interval at which resolvers query the discovery service
+	refresh time.Duration
+	// Options with which resolvers dial the discovery service
+	dialOpts []grpc.DialOption
+}
+
+// shardResolver resolves a shard target to the addresses of the data servers
+// of the shard, following the discovery service or the view of a client.
+type shardResolver struct {
+	// Identifier of the shard resolved
+	shardID int32
+	// Connection notified of the resolved addresses
+	cc resolver.ClientConn
+	// Publisher of the client view followed, or nil if the resolver queries
+	// the discovery service itself
+	publisher *viewPublisher
+	// Channel holding the latest view not yet applied
+	views chan []*discovery.Shard
+	// Channel signaled when gRPC asks for the target to be resolved again
+	resolveNow chan struct{}
+	// Channel closed when the resolver is closed
+	done chan struct{}
+	// Ensures the resolver is closed only once
+	closeOnce sync.Once
+}
+
+// viewPublisher notifies the resolvers following a client of changes to its
+// view.
+type viewPublisher struct {
+	// Authority of the targets resolved with the view
+	authority string
+	// Latest view published, or nil if none
+	view []*discovery.Shard
+	// Resolvers following the view
+	resolvers map[*shardResolver]struct{}
+	// Mutex for accessing view and resolvers
+	mu sync.Mutex
+}
+
+// NewResolverBuilder returns a builder of resolvers for the scalog scheme,
+// which query the discovery service at the authority of a target every refresh
+// interval, and whenever gRPC asks, dialing it with opts. The package
+// registers a builder dialing insecurely; register another with
+// resolver.Register to change the interval or dial options:
+//
+//	resolver.Register(lib.NewResolverBuilder(time.Minute, grpc.WithTransportCredentials(creds)))
+//	conn, err := grpc.Dial(lib.ShardTarget("10.0.0.10:8000", 0), grpc.WithTransportCredentials(creds))
+//
+// Connections to a shard target spread requests across the replicas of the
+// shard with the replica balancer.
+func NewResolverBuilder(refresh time.Duration, opts ...grpc.DialOption) resolver.Builder {
+	return &resolverBuilder{refresh: refresh, dialOpts: opts}
+}
+
+// ShardTarget returns the gRPC target of a shard resolved through the discovery
+// service at an address. The resolver registered by the package dials the
+// discovery service without transport security, so register a resolver built
+// with NewResolverBuilder and transport credentials before dialing the target
+// if the discovery service requires TLS or is reached over untrusted networks.
+// Clients created with WithBalancer resolve their targets from their own view
+// and do not dial the discovery service from the resolver.
+func ShardTarget(discoveryAddress string, shardID int32) string {
+	return fmt.Sprintf("%s://%s/%s%d", ResolverScheme, discoveryAddress, shardEndpointPrefix, shardID)
+}
+
+// Scheme returns the scheme of the targets resolved.
+func (b *resolverBuilder) Scheme() string {
+	return ResolverScheme
+}
+
+// Build returns a resolver of a shard target. Targets whose authority was
+// registered by a Client follow its view, and other targets query the
+// discovery service at their authority.
+func (b *resolverBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOption) (resolver.Resolver, error) {
+	if !strings.HasPrefix(target.Endpoint, shardEndpointPrefix) {
+		return nil, fmt.Errorf("Invalid shard target endpoint %s", target.Endpoint)
+	}
+	shardID, err := strconv.ParseInt(strings.TrimPrefix(target.Endpoint, shardEndpointPrefix), 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid shard target endpoint %s: %v", target.Endpoint, err)
+	}
+	r := &shardResolver{
+		shardID:    int32(shardID),
+		cc:         cc,
+		views:      make(chan []*discovery.Shard, 1),
+		resolveNow: make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	go r.run()
+	viewPublishersMu.Lock()
+	r.publisher = viewPublishers[target.Authority]
+	viewPublishersMu.Unlock()
+	if r.publisher != nil {
+		r.publisher.add(r)
+		return r, nil
+	}
+	go r.poll(target.Authority, b.refresh, b.dialOpts)
+	return r, nil
+}
+
+// ResolveNow asks the resolver to query the discovery service again.
+func (r *shardResolver) ResolveNow(opts resolver.ResolveNowOption) {
+	select {
+	case r.resolveNow <- struct{}{}:
+	default:
+	}
+}
+
+// Close stops the resolver.
+func (r *shardResolver) Close() {
+	r.closeOnce.Do(func() {
+		close(r.done)
+		if r.publisher != nil {
+			r.publisher.remove(r)
+		}
+	})
+}
+
+// offer replaces the view not yet applied by the resolver with a newer view.
+// offer must not be called concurrently.
+func (r *shardResolver) offer(view []*discovery.Shard) {
+	select {
+	case <-r.views:
+	default:
+	}
+	r.views <- view
+}
+
+// run applies the views offered to the resolver until it is closed.
+func (r *shardResolver) run() {
+	for {
+		select {
+		case <-r.done:
+			return
+		case view := <-r.views:
+			r.update(view)
+		}
+	}
+}
+
+// update notifies gRPC of the addresses of the data servers of the shard in a
+// view.
+func (r *shardResolver) update(view []*discovery.Shard) {
+	var addresses []resolver.Address
+	for _, shard := range view {
+		if shard.ShardID != r.shardID {
+			continue
+		}
+		for _, server := range shard.Servers {
+			addresses = append(addresses, resolver.Address{Addr: getAddressOfServer(server), Metadata: server.ServerID})
+		}
+	}
+	r.cc.UpdateState(resolver.State{Addresses: addresses, ServiceConfig: serviceConfig})
+}
+
+// poll queries the discovery service at an address every refresh interval and
+// whenever gRPC asks, until the resolver is closed. Failures are logged to
+// grpclog and retried with exponential backoff, up to the refresh interval.
+func (r *shardResolver) poll(address string, refresh time.Duration, dialOpts []grpc.DialOption) {
+	var conn *grpc.ClientConn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	backoff := discoveryRetryBackoff
+	for {
+		var err error
+		if conn == nil {
+			conn, err = grpc.Dial(address, dialOpts...)
+		}
+		wait := refresh
+		if err == nil {
+			var resp *discovery.DiscoverResponse
+			ctx, cancel := context.WithTimeout(context.Background(), defaultDiscoveryTimeout)
+			resp, err = discovery.NewDiscoveryClient(conn).DiscoverServers(ctx, &discovery.DiscoverRequest{})
+			cancel()
+			if err == nil {
+				r.offer(resp.Shards)
+				backoff = discoveryRetryBackoff
+			}
+		}
+		if err != nil {
+			grpclog.Warningf("scalog: Failed to resolve shard %d with discovery service %s: %v", r.shardID, address, err)
+			wait = backoff
+			if backoff < refresh {
+				backoff *= 2
+			}
+		}
+		select {
+		case <-r.done:
+			return
+		case <-r.resolveNow:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// newViewPublisher registers and returns a publisher under a new authority.
+func newViewPublisher() *viewPublisher {
+	p := &viewPublisher{
+		authority: fmt.Sprintf("client-%d", atomic.AddInt64(&publisherCount, 1)),
+		resolvers: make(map[*shardResolver]struct{}),
+	}
+	viewPublishersMu.Lock()
+	viewPublishers[p.authority] = p
+	viewPublishersMu.Unlock()
+	return p
+}
+
+// close unregisters the publisher's authority.
+func (p *viewPublisher) close() {
+	viewPublishersMu.Lock()
+	delete(viewPublishers, p.authority)
+	viewPublishersMu.Unlock()
+}
+
+// publish offers a view to the resolvers following the publisher.
+func (p *viewPublisher) publish(view []*discovery.Shard) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.view = view
+	for r := range p.resolvers {
+		r.offer(view)
+	}
+}
+
+// add makes a resolver follow the publisher, offering it the latest view.
+func (p *viewPublisher) add(r *shardResolver) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.resolvers[r] = struct{}{}
+	if p.view != nil {
+		r.offer(p.view)
+	}
+}
+
+// remove stops a resolver from following the publisher.
+func (p *viewPublisher) remove(r *shardResolver) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.resolvers, r)
+}
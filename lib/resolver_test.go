@@ -0,0 +1,49 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	data "github.com/scalog/scalog/data/messaging"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+)
+
+func TestResolverShardTarget(t *testing.T) {
+	cluster := newTestCluster(t)
+	defer cluster.Close()
+	ip, port := cluster.DiscoveryAddress()
+	conn, err := grpc.Dial(ShardTarget(fmt.Sprintf("%s:%d", ip, port), 1), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	resp, err := data.NewDataClient(conn).Append(ctx, &data.AppendRequest{Cid: 1, Csn: 0, Record: "Hello, World!"}, grpc.WaitForReady(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record := cluster.Records()[resp.Gsn]; record != "Hello, World!" {
+		t.Fatalf("Expected: %s, Actual: %s", "Hello, World!", record)
+	}
+	// Reads are spread across both replicas of the shard
+	replicas := make(map[string]int)
+	for i := 0; i < 100 && len(replicas) < 2; i++ {
+		var p peer.Peer
+		read, err := data.NewDataClient(conn).Read(ctx, &data.ReadRequest{Gsn: resp.Gsn}, grpc.WaitForReady(true), grpc.Peer(&p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if read.Record != "Hello, World!" {
+			t.Fatalf("Expected: %s, Actual: %s", "Hello, World!", read.Record)
+		}
+		replicas[p.Addr.String()]++
+		time.Sleep(5 * time.Millisecond)
+	}
+	if len(replicas) != 2 {
+		t.Fatalf("Expected: %d replicas, Actual: %v", 2, replicas)
+	}
+}
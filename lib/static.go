@@ -0,0 +1,73 @@
+package lib
+
+import (
+	"fmt"
+
+	discovery "github.com/scalog/scalog/discovery/rpc"
+)
+
+// StaticShard is a shard of a static view, listing the data servers of the
+// shard for clients that run without the discovery service.
+type StaticShard struct {
+	ShardID int32          `yaml:"shard-id"`
+	Servers []StaticServer `yaml:"servers"`
+}
+
+// StaticServer is a data server of a StaticShard.
+type StaticServer struct {
+	ServerID int32  `yaml:"server-id"`
+	IP       string `yaml:"ip"`
+	Port     int32  `yaml:"port"`
+}
+
+// WithStaticView sets a static view of the shards and data servers, overriding
+// static-view in config.yaml. If no discovery endpoint is configured, the
+// client uses the static view and never queries the discovery service.
+// Otherwise the static view is used only while every discovery endpoint is
+// unreachable.
+func WithStaticView(shards ...StaticShard) Option {
+	return func(c *Client) error {
+		c.config.StaticView = shards
+		return nil
+	}
+}
+
+// validateStaticView returns an error if a static view has shards without data
+// servers, duplicate identifiers or incomplete addresses.
+func validateStaticView(shards []StaticShard) error {
+	shardIDs := make(map[int32]bool)
+	serverIDs := make(map[int32]bool)
+	for _, shard := range shards {
+		if shardIDs[shard.ShardID] {
+			return fmt.Errorf("Duplicate shard %d in static view", shard.ShardID)
+		}
+		shardIDs[shard.ShardID] = true
+		if len(shard.Servers) == 0 {
+			return fmt.Errorf("Shard %d in static view has no data servers", shard.ShardID)
+		}
+		for _, server := range shard.Servers {
+			if serverIDs[server.ServerID] {
+				return fmt.Errorf("Duplicate data server %d in static view", server.ServerID)
+			}
+			serverIDs[server.ServerID] = true
+			if server.IP == "" || server.Port <= 0 {
+				return fmt.Errorf("Data server %d in static view needs an IP and port", server.ServerID)
+			}
+		}
+	}
+	return nil
+}
+
+// staticView returns a static view in the form returned by the discovery
+// service.
+func staticView(shards []StaticShard) []*discovery.Shard {
+	view := make([]*discovery.Shard, len(shards))
+	for i, shard := range shards {
+		servers := make([]*discovery.DataServer, len(shard.Servers))
+		for j, server := range shard.Servers {
+			servers[j] = &discovery.DataServer{ServerID: server.ServerID, Ip: server.IP, Port: server.Port}
+		}
+		view[i] = &discovery.Shard{ShardID: shard.ShardID, Servers: servers}
+	}
+	return view
+}
@@ -0,0 +1,77 @@
+package lib
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/scalog/scalog-client/scalogtest"
+)
+
+// staticViewOf returns the static view of an in-process cluster.
+func staticViewOf(cluster *scalogtest.Cluster) []StaticShard {
+	var shards []StaticShard
+	// Map from shard identifier to index in shards
+	index := make(map[int32]int)
+	for _, server := range cluster.Servers() {
+		i, in := index[server.ShardID()]
+		if !in {
+			i = len(shards)
+			index[server.ShardID()] = i
+			shards = append(shards, StaticShard{ShardID: server.ShardID()})
+		}
+		ip, port := server.Address()
+		shards[i].Servers = append(shards[i].Servers, StaticServer{ServerID: server.ServerID(), IP: ip, Port: port})
+	}
+	return shards
+}
+
+func TestStaticView(t *testing.T) {
+	cluster := newTestCluster(t)
+	defer cluster.Close()
+	client, err := NewClient(WithStaticView(staticViewOf(cluster)...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	gsn, err := client.Append("Hello, World!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record := cluster.Records()[gsn]; record != "Hello, World!" {
+		t.Fatalf("Expected: %s, Actual: %s", "Hello, World!", record)
+	}
+}
+
+func TestStaticViewFallback(t *testing.T) {
+	cluster := newTestCluster(t)
+	defer cluster.Close()
+	client, err := NewClient(
+		WithDiscoveryAddress(deadAddress(t)),
+		WithStaticView(staticViewOf(cluster)...),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if len(client.view) != 2 {
+		t.Fatalf("Expected: %d, Actual: %d", 2, len(client.view))
+	}
+	if _, err := client.Append("Hello, World!"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStaticViewInvalid(t *testing.T) {
+	for _, shards := range [][]StaticShard{
+		{{ShardID: 0}},
+		{{ShardID: 0, Servers: []StaticServer{{ServerID: 0, IP: "127.0.0.1"}}}},
+		{
+			{ShardID: 0, Servers: []StaticServer{{ServerID: 0, IP: "127.0.0.1", Port: 26000}}},
+			{ShardID: 1, Servers: []StaticServer{{ServerID: 0, IP: "127.0.0.1", Port: 26001}}},
+		},
+	} {
+		if err := validateStaticView(shards); err == nil {
+			t.Fatalf("Expected error for static view %s", fmt.Sprint(shards))
+		}
+	}
+}
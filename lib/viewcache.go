@@ -0,0 +1,133 @@
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"time"
+
+	discovery "github.com/scalog/scalog/discovery/rpc"
+	"gopkg.in/yaml.v2"
+)
+
+// viewCache is the view of a client persisted in a local file, so that a
+// restarted client can start without waiting for the discovery service.
+type viewCache struct {
+	// Version of the view
+	ViewID int32 `yaml:"view-id"`
+	// Time at which the view was last refreshed
+	Updated time.Time `yaml:"updated"`
+	// Shards and data servers of the view, with translated addresses
+	View []StaticShard `yaml:"view"`
+}
+
+// WithViewCache persists the client's view in the file at path. NewClient
+// starts from the cached view, if it was refreshed within maxStaleness, and
+// queries the discovery service in the background instead of waiting for it.
+// A maxStaleness of 0 accepts a cached view of any age.
+func WithViewCache(path string, maxStaleness time.Duration) Option {
+	return func(c *Client) error {
+		c.config.ViewCache = path
+		c.config.ViewCacheMaxStaleness = maxStaleness
+		return nil
+	}
+}
+
+// loadViewCache reads the view cached at path, returning nil if no file exists.
+func loadViewCache(path string) (*viewCache, error) {
+	file, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cache := &viewCache{}
+	err = yaml.Unmarshal(file, cache)
+	if err != nil {
+		return nil, err
+	}
+	return cache, nil
+}
+
+// startFromViewCache sets the client's view to the cached view and returns
+// whether the cache was usable.
+func (c *Client) startFromViewCache() bool {
+	config := c.getConfig()
+	if config.ViewCache == "" {
+		return false
+	}
+	cache, err := loadViewCache(config.ViewCache)
+	if err != nil {
+		c.logger.Warn("Failed to read view cache", "path", config.ViewCache, "err", err)
+		return false
+	}
+	if cache == nil || len(cache.View) == 0 {
+		return false
+	}
+	if age := time.Since(cache.Updated); config.ViewCacheMaxStaleness > 0 && age > config.ViewCacheMaxStaleness {
+		c.logger.Info("Ignoring stale view cache", "path", config.ViewCache, "age", age)
+		return false
+	}
+	c.setView(staticView(cache.View))
+	c.viewID = cache.ViewID
+	c.logger.Info("Started from view cache", "path", config.ViewCache, "viewID", cache.ViewID, "shards", len(c.view))
+	return true
+}
+
+// refreshView queries the discovery service until it answers or the client is
+// closed, and replaces the view the client started from with the view
+// returned, whose version is learned from the next data server response.
+func (c *Client) refreshView() {
+	backoff := discoveryRetryBackoff
+	for {
+		config := c.getConfig()
+		view, err := c.discover(config, c.getTLSConfig())
+		if err == nil {
+			c.viewMu.Lock()
+			c.setView(view)
+			c.viewID = unknownViewID
+			c.saveViewCache(config)
+			c.viewMu.Unlock()
+			return
+		}
+		select {
+		case <-c.done:
+			return
+		case <-time.After(backoff):
+		}
+		if backoff < maxResubscribeBackoff {
+			backoff *= 2
+		}
+	}
+}
+
+// saveViewCache persists the client's view and view identifier to the view
+// cache of a configuration, if any. Failures are logged rather than returned,
+// since the cache is only an optimization. The caller must hold viewMu or be
+// the only user of the client.
+func (c *Client) saveViewCache(config *config) {
+	if config.ViewCache == "" {
+		return
+	}
+	cache := viewCache{ViewID: c.viewID, Updated: time.Now(), View: staticShardsOf(c.view)}
+	out, err := yaml.Marshal(&cache)
+	if err == nil {
+		err = writeFileAtomic(config.ViewCache, out)
+	}
+	if err != nil {
+		c.logger.Warn("Failed to write view cache", "path", config.ViewCache, "err", err)
+	}
+}
+
+// staticShardsOf returns a view in the form of a static view.
+func staticShardsOf(view []*discovery.Shard) []StaticShard {
+	shards := make([]StaticShard, len(view))
+	for i, shard := range view {
+		servers := make([]StaticServer, len(shard.Servers))
+		for j, server := range shard.Servers {
+			servers[j] = StaticServer{ServerID: server.ServerID, IP: server.Ip, Port: server.Port}
+		}
+		shards[i] = StaticShard{ShardID: shard.ShardID, Servers: servers}
+	}
+	return shards
+}
@@ -0,0 +1,97 @@
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestViewCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scalog-view-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "view.yaml")
+	cluster := newTestCluster(t)
+	defer cluster.Close()
+	client := newTestClient(t, cluster, WithViewCache(path, time.Minute))
+	client.Close()
+	cache, err := loadViewCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cache == nil || len(cache.View) != 2 {
+		t.Fatalf("Expected: %d shards, Actual: %v", 2, cache)
+	}
+
+	// Start from the cache while the discovery service is unreachable
+	client, err = NewClient(WithDiscoveryAddress(deadAddress(t)), WithViewCache(path, time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gsn, err := client.Append("Hello, World!")
+	client.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record := cluster.Records()[gsn]; record != "Hello, World!" {
+		t.Fatalf("Expected: %s, Actual: %s", "Hello, World!", record)
+	}
+
+	// Ignore the cache once it is stale
+	time.Sleep(10 * time.Millisecond)
+	_, err = NewClient(WithDiscoveryAddress(deadAddress(t)), WithViewCache(path, time.Millisecond))
+	if err == nil {
+		t.Fatalf("Expected error when starting from a stale view cache")
+	}
+}
+
+func TestViewCacheRefresh(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scalog-view-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "view.yaml")
+	cluster := newTestCluster(t)
+	defer cluster.Close()
+	// A cached view whose version is older than the cluster's
+	cached := viewCache{ViewID: cluster.ViewID() + 7, Updated: time.Now(), View: staticViewOf(cluster)}
+	out, err := yaml.Marshal(&cached)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, out, 0600); err != nil {
+		t.Fatal(err)
+	}
+	client := newTestClient(t, cluster, WithViewCache(path, time.Minute))
+	defer client.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		client.viewMu.RLock()
+		viewID := client.viewID
+		client.viewMu.RUnlock()
+		if viewID == unknownViewID {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected: %d, Actual: %d", unknownViewID, viewID)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := client.Append("Hello, World!"); err != nil {
+		t.Fatal(err)
+	}
+	cache, err := loadViewCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cache.ViewID != cluster.ViewID() {
+		t.Fatalf("Expected: %d, Actual: %d", cluster.ViewID(), cache.ViewID)
+	}
+}
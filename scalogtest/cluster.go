@@ -0,0 +1,305 @@
+// Package scalogtest provides an in-process Scalog cluster for testing
+// applications and the Scalog client without a live deployment. The cluster
+// serves the Discovery and Data gRPC services on loopback listeners, assigns
+// global sequence numbers in append order across shards, and implements
+// Subscribe, Read and Trim with the same semantics as Scalog. Faults can be
+// injected into the cluster to test how clients handle failures.
+package scalogtest
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"sync"
+
+	data "github.com/scalog/scalog/data/messaging"
+	discovery "github.com/scalog/scalog/discovery/rpc"
+	"google.golang.org/grpc"
+)
+
+// loopbackIP is the IP on which the cluster's servers listen.
+const loopbackIP = "127.0.0.1"
+
+// Cluster is an in-process Scalog cluster consisting of a discovery service and
+// replicated data servers grouped by shard.
+type Cluster struct {
+	// Number of shards
+	shards int
+	// Server and listener of the discovery service
+	discoveryServer   *grpc.Server
+	discoveryListener net.Listener
+	// Data servers in order of server identifier
+	servers []*Server
+	// Global log shared by all data servers
+	log *globalLog
+}
+
+// Server is a data server of a Cluster.
+type Server struct {
+	// Identifier of the server
+	serverID int32
+	// Identifier of the shard the server belongs to
+	shardID int32
+	// Records before this global sequence number have been trimmed from the
+	// server
+	trimGsn int32
+	// Faults injected into the server
+	fault Fault
+	// Open subscriptions to the server
+	subscriptions map[*subscription]struct{}
+	// gRPC server and listener of the data server
+	grpcServer *grpc.Server
+	listener   net.Listener
+	// Global log shared by all data servers
+	log *globalLog
+	// Mutex for accessing the server's shard, trims, faults and subscriptions
+	mu sync.Mutex
+}
+
+// entry represents a record in the global log.
+type entry struct {
+	// Identifier of the shard the record was appended to
+	shardID int32
+	// Data of record
+	record string
+}
+
+// clientRecord identifies a record by the client that appended it.
+type clientRecord struct {
+	cid int32
+	csn int32
+}
+
+// globalLog is the totally ordered log of records appended to a Cluster.
+type globalLog struct {
+	// Global sequence number to be assigned to the next record
+	nextGsn int32
+	// Version of the cluster's view
+	viewID int32
+	// Map from global sequence number to record
+	entries map[int32]entry
+	// Map from client record to the global sequence number assigned to it
+	appended map[clientRecord]int32
+	// Channel closed and replaced whenever the log changes
+	changed chan struct{}
+	// Mutex for accessing the log
+	mu sync.Mutex
+}
+
+// NewCluster starts an in-process cluster with a number of shards, each
+// replicated on a number of data servers. Servers are assigned identifiers in
+// order of shard, starting from 0.
+func NewCluster(shards int, replicas int) (*Cluster, error) {
+	if shards < 1 || replicas < 1 {
+		return nil, fmt.Errorf("Cluster must have at least one shard and one replica")
+	}
+	c := &Cluster{
+		shards: shards,
+		log: &globalLog{
+			nextGsn:  1,
+			entries:  make(map[int32]entry),
+			appended: make(map[clientRecord]int32),
+			changed:  make(chan struct{}),
+		},
+	}
+	for shardID := 0; shardID < shards; shardID++ {
+		for replica := 0; replica < replicas; replica++ {
+			server, err := c.startServer(int32(shardID), int32(shardID*replicas+replica))
+			if err != nil {
+				c.Close()
+				return nil, err
+			}
+			c.servers = append(c.servers, server)
+		}
+	}
+	lis, err := net.Listen("tcp", loopbackIP+":0")
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	c.discoveryListener = lis
+	c.discoveryServer = grpc.NewServer()
+	discovery.RegisterDiscoveryServer(c.discoveryServer, &discoveryService{cluster: c})
+	go c.discoveryServer.Serve(lis)
+	return c, nil
+}
+
+// DiscoveryAddress returns the IP and port of the cluster's discovery service.
+func (c *Cluster) DiscoveryAddress() (string, int32) {
+	return loopbackIP, int32(c.discoveryListener.Addr().(*net.TCPAddr).Port)
+}
+
+// Servers returns the cluster's data servers in order of server identifier.
+func (c *Cluster) Servers() []*Server {
+	servers := make([]*Server, len(c.servers))
+	copy(servers, c.servers)
+	return servers
+}
+
+// Server returns the data server with an identifier.
+func (c *Cluster) Server(serverID int32) (*Server, error) {
+	if serverID < 0 || int(serverID) >= len(c.servers) {
+		return nil, fmt.Errorf("Server %d does not exist", serverID)
+	}
+	return c.servers[serverID], nil
+}
+
+// ViewID returns the version of the cluster's view.
+func (c *Cluster) ViewID() int32 {
+	return c.log.currentViewID()
+}
+
+// Records returns the committed records that have not been trimmed from every
+// data server, keyed by global sequence number.
+func (c *Cluster) Records() map[int32]string {
+	trimGsn := int32(math.MaxInt32)
+	for _, server := range c.servers {
+		server.mu.Lock()
+		if server.trimGsn < trimGsn {
+			trimGsn = server.trimGsn
+		}
+		server.mu.Unlock()
+	}
+	c.log.mu.Lock()
+	defer c.log.mu.Unlock()
+	records := make(map[int32]string, len(c.log.entries))
+	for gsn, e := range c.log.entries {
+		if gsn >= trimGsn {
+			records[gsn] = e.record
+		}
+	}
+	return records
+}
+
+// Close stops all of the cluster's servers.
+func (c *Cluster) Close() {
+	if c.discoveryServer != nil {
+		c.discoveryServer.Stop()
+	}
+	for _, server := range c.servers {
+		server.grpcServer.Stop()
+	}
+}
+
+// ServerID returns the identifier of the data server.
+func (s *Server) ServerID() int32 {
+	return s.serverID
+}
+
+// ShardID returns the identifier of the shard the data server belongs to.
+func (s *Server) ShardID() int32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.shardID
+}
+
+// Address returns the IP and port of the data server.
+func (s *Server) Address() (string, int32) {
+	return loopbackIP, int32(s.listener.Addr().(*net.TCPAddr).Port)
+}
+
+// Trim deletes the records before a global sequence number from the data
+// server only, leaving them on the other servers.
+func (s *Server) Trim(gsn int32) {
+	s.mu.Lock()
+	if gsn > s.trimGsn {
+		s.trimGsn = gsn
+	}
+	s.mu.Unlock()
+	s.log.notify()
+}
+
+// startServer starts a data server in a shard.
+func (c *Cluster) startServer(shardID int32, serverID int32) (*Server, error) {
+	lis, err := net.Listen("tcp", loopbackIP+":0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		serverID:      serverID,
+		shardID:       shardID,
+		trimGsn:       1,
+		subscriptions: make(map[*subscription]struct{}),
+		listener:      lis,
+		log:           c.log,
+	}
+	s.grpcServer = grpc.NewServer(
+		grpc.UnaryInterceptor(s.unaryFaultInterceptor),
+		grpc.StreamInterceptor(s.streamFaultInterceptor),
+	)
+	data.RegisterDataServer(s.grpcServer, &dataService{server: s})
+	go s.grpcServer.Serve(lis)
+	return s, nil
+}
+
+// view returns the cluster's discoverable data servers grouped by shard.
+func (c *Cluster) view() []*discovery.Shard {
+	shards := make(map[int32]*discovery.Shard)
+	for _, server := range c.servers {
+		server.mu.Lock()
+		shardID, hidden := server.shardID, server.fault.Hidden
+		server.mu.Unlock()
+		if hidden {
+			continue
+		}
+		shard, in := shards[shardID]
+		if !in {
+			shard = &discovery.Shard{ShardID: shardID}
+			shards[shardID] = shard
+		}
+		ip, port := server.Address()
+		shard.Servers = append(shard.Servers, &discovery.DataServer{
+			ServerID: server.serverID,
+			Ip:       ip,
+			Port:     port,
+		})
+	}
+	view := make([]*discovery.Shard, 0, len(shards))
+	for _, shard := range shards {
+		view = append(view, shard)
+	}
+	sort.Slice(view, func(i, j int) bool {
+		return view[i].ShardID < view[j].ShardID
+	})
+	return view
+}
+
+// append assigns the next global sequence number to a record appended to a
+// shard, or returns the global sequence number already assigned to the record
+// if the client appended it before.
+func (l *globalLog) append(shardID int32, cid int32, csn int32, record string) (int32, int32) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key := clientRecord{cid: cid, csn: csn}
+	if gsn, in := l.appended[key]; in {
+		return gsn, l.viewID
+	}
+	gsn := l.nextGsn
+	l.nextGsn++
+	l.entries[gsn] = entry{shardID: shardID, record: record}
+	l.appended[key] = gsn
+	l.notifyLocked()
+	return gsn, l.viewID
+}
+
+// currentViewID returns the version of the cluster's view.
+func (l *globalLog) currentViewID() int32 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.viewID
+}
+
+// notify wakes up the subscriptions waiting for the log or a server to change.
+func (l *globalLog) notify() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.notifyLocked()
+}
+
+// notifyLocked wakes up the subscriptions waiting for the log or a server to
+// change. The caller must hold mu.
+func (l *globalLog) notifyLocked() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
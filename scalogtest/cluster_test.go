@@ -0,0 +1,38 @@
+package scalogtest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	data "github.com/scalog/scalog/data/messaging"
+	"google.golang.org/grpc"
+)
+
+func TestAppendDeduplication(t *testing.T) {
+	cluster, err := NewCluster(1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cluster.Close()
+	var gsns []int32
+	for _, server := range cluster.Servers() {
+		ip, port := server.Address()
+		conn, err := grpc.Dial(fmt.Sprintf("%s:%d", ip, port), grpc.WithInsecure())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		resp, err := data.NewDataClient(conn).Append(context.Background(), &data.AppendRequest{Cid: 1, Csn: 0, Record: "Hello, World!"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		gsns = append(gsns, resp.Gsn)
+	}
+	if gsns[0] != gsns[1] {
+		t.Fatalf("Expected: %d, Actual: %d", gsns[0], gsns[1])
+	}
+	if len(cluster.Records()) != 1 {
+		t.Fatalf("Expected: %d, Actual: %d", 1, len(cluster.Records()))
+	}
+}
@@ -0,0 +1,138 @@
+package scalogtest
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Fault describes the faults injected into a data server. The zero value
+// injects no faults.
+type Fault struct {
+	// Delay before the server handles each request
+	Latency time.Duration `yaml:"latency"`
+	// Whether the server fails every request with codes.Unavailable
+	Unavailable bool `yaml:"unavailable"`
+	// Whether the server acknowledges trims without applying them
+	IgnoreTrims bool `yaml:"ignore-trims"`
+	// Whether the server is omitted from the discovery service's view
+	Hidden bool `yaml:"hidden"`
+}
+
+// subscription is an open Subscribe stream of a data server.
+type subscription struct {
+	// Channel on which the error ending the stream is sent, or nil if the
+	// stream ends normally
+	end chan error
+}
+
+// SetFault replaces the faults injected into the data server.
+func (s *Server) SetFault(fault Fault) {
+	s.mu.Lock()
+	s.fault = fault
+	s.mu.Unlock()
+}
+
+// Fault returns the faults injected into the data server.
+func (s *Server) Fault() Fault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fault
+}
+
+// ResetStreams ends the data server's open Subscribe streams with
+// codes.Unavailable, as if the connections were reset.
+func (s *Server) ResetStreams() {
+	s.endSubscriptions(status.Error(codes.Unavailable, "Stream reset by scalogtest"))
+}
+
+// DropStreams ends the data server's open Subscribe streams as if the server
+// had no more records to send.
+func (s *Server) DropStreams() {
+	s.endSubscriptions(nil)
+}
+
+// ChangeView increments the cluster's ViewID, which data servers return in
+// every response. If reshuffle is set, every data server is also moved to the
+// next shard, so that clients holding the old view reach the wrong servers.
+// ChangeView returns the new ViewID.
+func (c *Cluster) ChangeView(reshuffle bool) int32 {
+	if reshuffle {
+		for _, server := range c.servers {
+			server.mu.Lock()
+			server.shardID = (server.shardID + 1) % int32(c.shards)
+			server.mu.Unlock()
+		}
+	}
+	c.log.mu.Lock()
+	defer c.log.mu.Unlock()
+	c.log.viewID++
+	c.log.notifyLocked()
+	return c.log.viewID
+}
+
+// endSubscriptions ends the data server's open Subscribe streams with err.
+func (s *Server) endSubscriptions(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for sub := range s.subscriptions {
+		select {
+		case sub.end <- err:
+		default:
+		}
+		delete(s.subscriptions, sub)
+	}
+}
+
+// subscribe registers an open Subscribe stream of the data server.
+func (s *Server) subscribe() *subscription {
+	sub := &subscription{end: make(chan error, 1)}
+	s.mu.Lock()
+	s.subscriptions[sub] = struct{}{}
+	s.mu.Unlock()
+	return sub
+}
+
+// unsubscribe unregisters an open Subscribe stream of the data server.
+func (s *Server) unsubscribe(sub *subscription) {
+	s.mu.Lock()
+	delete(s.subscriptions, sub)
+	s.mu.Unlock()
+}
+
+// injectFault delays and fails a request according to the data server's
+// faults.
+func (s *Server) injectFault(ctx context.Context) error {
+	fault := s.Fault()
+	if fault.Latency > 0 {
+		select {
+		case <-time.After(fault.Latency):
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+	if fault.Unavailable {
+		return status.Errorf(codes.Unavailable, "Server %d unavailable", s.serverID)
+	}
+	return nil
+}
+
+// unaryFaultInterceptor injects the data server's faults into unary requests.
+func (s *Server) unaryFaultInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := s.injectFault(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// streamFaultInterceptor injects the data server's faults into streaming
+// requests.
+func (s *Server) streamFaultInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := s.injectFault(ss.Context()); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
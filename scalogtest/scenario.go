@@ -0,0 +1,114 @@
+package scalogtest
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Actions a Step can perform.
+const (
+	// ActionFault replaces the faults injected into a server.
+	ActionFault = "fault"
+	// ActionResetStreams resets a server's open Subscribe streams.
+	ActionResetStreams = "reset-streams"
+	// ActionDropStreams drops a server's open Subscribe streams.
+	ActionDropStreams = "drop-streams"
+	// ActionTrim trims records from a server only.
+	ActionTrim = "trim"
+	// ActionChangeView increments the cluster's ViewID.
+	ActionChangeView = "change-view"
+)
+
+// Scenario is a schedule of faults injected into a Cluster. A scenario can be
+// written in YAML, for example:
+//
+//	steps:
+//	  - after: 100ms
+//	    action: fault
+//	    server: 1
+//	    fault:
+//	      latency: 50ms
+//	      unavailable: true
+//	  - after: 1s
+//	    action: change-view
+//	    reshuffle: true
+type Scenario struct {
+	// Steps performed in order
+	Steps []Step `yaml:"steps"`
+}
+
+// Step is an action performed on a Cluster as part of a Scenario.
+type Step struct {
+	// Delay after the previous step, or after the start of the scenario,
+	// before the action is performed
+	After time.Duration `yaml:"after"`
+	// Action to perform, one of the Action constants
+	Action string `yaml:"action"`
+	// Identifier of the server the action targets, if any
+	Server int32 `yaml:"server"`
+	// Faults injected into the server by ActionFault
+	Fault Fault `yaml:"fault"`
+	// Global sequence number before which ActionTrim trims records
+	Gsn int32 `yaml:"gsn"`
+	// Whether ActionChangeView moves servers to other shards
+	Reshuffle bool `yaml:"reshuffle"`
+}
+
+// LoadScenario reads a Scenario from a YAML file.
+func LoadScenario(path string) (*Scenario, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	scenario := &Scenario{}
+	if err := yaml.UnmarshalStrict(b, scenario); err != nil {
+		return nil, fmt.Errorf("Failed to parse scenario %s: %v", path, err)
+	}
+	return scenario, nil
+}
+
+// Run performs the steps of a scenario in order, waiting the delay of each
+// step before performing it. Run returns when all steps have been performed,
+// when a step fails, or when ctx is done.
+func (c *Cluster) Run(ctx context.Context, scenario *Scenario) error {
+	for i, step := range scenario.Steps {
+		select {
+		case <-time.After(step.After):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if err := c.Apply(step); err != nil {
+			return fmt.Errorf("Step %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// Apply performs the action of a step immediately, ignoring its delay.
+func (c *Cluster) Apply(step Step) error {
+	if step.Action == ActionChangeView {
+		c.ChangeView(step.Reshuffle)
+		return nil
+	}
+	server, err := c.Server(step.Server)
+	if err != nil {
+		return err
+	}
+	switch step.Action {
+	case ActionFault:
+		server.SetFault(step.Fault)
+	case ActionResetStreams:
+		server.ResetStreams()
+	case ActionDropStreams:
+		server.DropStreams()
+	case ActionTrim:
+		server.Trim(step.Gsn)
+	default:
+		return fmt.Errorf("Unknown action %q", step.Action)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package scalogtest
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRunScenario(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scalogtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "scenario.yaml")
+	err = ioutil.WriteFile(path, []byte(`
+steps:
+  - action: fault
+    server: 1
+    fault:
+      latency: 10ms
+      unavailable: true
+      hidden: true
+  - after: 1ms
+    action: trim
+    server: 0
+    gsn: 5
+  - action: change-view
+    reshuffle: true
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scenario, err := LoadScenario(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cluster, err := NewCluster(2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cluster.Close()
+	err = cluster.Run(context.Background(), scenario)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Fault{Latency: 10 * time.Millisecond, Unavailable: true, Hidden: true}
+	if actual := cluster.servers[1].Fault(); actual != expected {
+		t.Fatalf("Expected: %+v, Actual: %+v", expected, actual)
+	}
+	if cluster.servers[0].trimGsn != 5 || cluster.servers[2].trimGsn != 1 {
+		t.Fatalf("Expected: %d, Actual: %d", 5, cluster.servers[0].trimGsn)
+	}
+	if cluster.ViewID() != 1 {
+		t.Fatalf("Expected: %d, Actual: %d", 1, cluster.ViewID())
+	}
+	if cluster.servers[0].ShardID() != 1 || cluster.servers[2].ShardID() != 0 {
+		t.Fatalf("Expected: %d, Actual: %d", 1, cluster.servers[0].ShardID())
+	}
+	view := cluster.view()
+	if len(view[1].Servers) != 1 {
+		t.Fatalf("Expected: %d, Actual: %d", 1, len(view[1].Servers))
+	}
+}
+
+func TestLoadScenarioUnknownField(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scalogtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "scenario.yaml")
+	err = ioutil.WriteFile(path, []byte("steps:\n  - action: fault\n    latncy: 1s\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = LoadScenario(path)
+	if err == nil {
+		t.Fatalf("Expected error for unknown field")
+	}
+}
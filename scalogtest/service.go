@@ -0,0 +1,108 @@
+package scalogtest
+
+import (
+	"context"
+
+	data "github.com/scalog/scalog/data/messaging"
+	discovery "github.com/scalog/scalog/discovery/rpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// discoveryService implements the Discovery gRPC service of a Cluster.
+type discoveryService struct {
+	cluster *Cluster
+}
+
+// dataService implements the Data gRPC service of a data server.
+type dataService struct {
+	server *Server
+}
+
+// DiscoverServers returns the cluster's data servers grouped by shard.
+func (s *discoveryService) DiscoverServers(ctx context.Context, req *discovery.DiscoverRequest) (*discovery.DiscoverResponse, error) {
+	return &discovery.DiscoverResponse{Shards: s.cluster.view()}, nil
+}
+
+// Append assigns a global sequence number to a record and commits it to the
+// server's shard.
+func (s *dataService) Append(ctx context.Context, req *data.AppendRequest) (*data.AppendResponse, error) {
+	gsn, viewID := s.server.log.append(s.server.ShardID(), req.Cid, req.Csn, req.Record)
+	return &data.AppendResponse{Csn: req.Csn, Gsn: gsn, ViewID: viewID}, nil
+}
+
+// Replicate is not supported, as replication between data servers is
+// implicit in the shared global log.
+func (s *dataService) Replicate(stream data.Data_ReplicateServer) error {
+	return status.Error(codes.Unimplemented, "Replicate is not supported by scalogtest")
+}
+
+// Subscribe streams the records of the server's shard starting from a global
+// sequence number, in order, until the client cancels the stream, the stream
+// is reset or dropped, or the server is stopped.
+func (s *dataService) Subscribe(req *data.SubscribeRequest, stream data.Data_SubscribeServer) error {
+	sub := s.server.subscribe()
+	defer s.server.unsubscribe(sub)
+	l := s.server.log
+	gsn := req.SubscriptionGsn
+	for {
+		l.mu.Lock()
+		changed := l.changed
+		s.server.mu.Lock()
+		shardID, trimGsn := s.server.shardID, s.server.trimGsn
+		s.server.mu.Unlock()
+		if gsn < trimGsn {
+			gsn = trimGsn
+		}
+		var pending []*data.SubscribeResponse
+		for ; gsn < l.nextGsn; gsn++ {
+			e, in := l.entries[gsn]
+			if in && e.shardID == shardID {
+				pending = append(pending, &data.SubscribeResponse{Gsn: gsn, Record: e.record, ViewID: l.viewID})
+			}
+		}
+		l.mu.Unlock()
+		for _, resp := range pending {
+			if err := stream.Send(resp); err != nil {
+				return err
+			}
+		}
+		select {
+		case <-changed:
+		case err := <-sub.end:
+			return err
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+// Trim deletes the records before a global sequence number from the server,
+// unless the server ignores trims.
+func (s *dataService) Trim(ctx context.Context, req *data.TrimRequest) (*data.TrimResponse, error) {
+	if !s.server.Fault().IgnoreTrims {
+		s.server.Trim(req.Gsn)
+	}
+	return &data.TrimResponse{ViewID: s.server.log.currentViewID()}, nil
+}
+
+// Read returns the record with a global sequence number if it was committed to
+// the server's shard. It fails with codes.OutOfRange if the record has been
+// trimmed from the server, and with codes.NotFound if the shard does not hold
+// the record.
+func (s *dataService) Read(ctx context.Context, req *data.ReadRequest) (*data.ReadResponse, error) {
+	s.server.mu.Lock()
+	shardID, trimGsn := s.server.shardID, s.server.trimGsn
+	s.server.mu.Unlock()
+	if req.Gsn < trimGsn {
+		return nil, status.Errorf(codes.OutOfRange, "Record %d has been trimmed", req.Gsn)
+	}
+	l := s.server.log
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, in := l.entries[req.Gsn]
+	if !in || e.shardID != shardID {
+		return nil, status.Errorf(codes.NotFound, "Record %d is not committed to shard %d", req.Gsn, shardID)
+	}
+	return &data.ReadResponse{Record: e.record, ViewID: l.viewID}, nil
+}
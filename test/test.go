@@ -1,22 +1,33 @@
 package test
 
 import (
+	"errors"
 	"fmt"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/scalog/scalog-client/checker"
 	clientlib "github.com/scalog/scalog-client/lib"
 )
 
+// receiveTimeout is how long StartConcurrent waits for each record of its
+// subscription before reporting the missing records.
+const receiveTimeout = 10 * time.Second
+
 type Test struct {
 	client *clientlib.Client
+	opts   []clientlib.Option
 }
 
-func NewTest() (*Test, error) {
-	client, err := clientlib.NewClient()
+func NewTest(opts ...clientlib.Option) (*Test, error) {
+	client, err := clientlib.NewClient(opts...)
 	if err != nil {
 		return nil, err
 	}
 	t := &Test{}
 	t.client = client
+	t.opts = opts
 	return t, err
 }
 
@@ -67,3 +78,112 @@ func (t *Test) Start() error {
 	fmt.Println("Test completed successfully")
 	return nil
 }
+
+// StartConcurrent appends records from a number of concurrent clients, then
+// subscribes to, reads and trims them, recording every operation. The history
+// is written to path, unless path is empty, and checked for consistency.
+// Records the subscription does not deliver in time and failed reads are
+// reported along with the violations found in the history.
+func (t *Test) StartConcurrent(clients int, num int, path string) error {
+	recorder := checker.NewRecorder()
+	recordedClients := make([]*checker.Client, clients)
+	for i := range recordedClients {
+		client, err := clientlib.NewClient(t.opts...)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		recordedClients[i] = recorder.Client(i, client)
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, clients)
+	minGsn := make([]int32, clients)
+	for i, client := range recordedClients {
+		wg.Add(1)
+		go func(i int, client *checker.Client) {
+			defer wg.Done()
+			minGsn[i] = -1
+			for j := 0; j < num; j++ {
+				gsn, _, err := client.Append(fmt.Sprintf("Record %d of client %d", j, i))
+				if err != nil {
+					errs <- err
+					return
+				}
+				if minGsn[i] < 0 || gsn < minGsn[i] {
+					minGsn[i] = gsn
+				}
+			}
+		}(i, client)
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		return err
+	}
+	from := minGsn[0]
+	for _, gsn := range minGsn {
+		if gsn < from {
+			from = gsn
+		}
+	}
+	total := int32(clients * num)
+	subscribeChan, err := recordedClients[0].Subscribe(from)
+	if err != nil {
+		return err
+	}
+	var failures []checker.Violation
+	var failuresMu sync.Mutex
+	fail := func(check string, gsn int32, message string) {
+		failuresMu.Lock()
+		failures = append(failures, checker.Violation{Check: check, Gsn: gsn, Message: message})
+		failuresMu.Unlock()
+	}
+receive:
+	for i := int32(0); i < total; i++ {
+		select {
+		case <-subscribeChan:
+		case <-time.After(receiveTimeout):
+			fail("subscribe", from, fmt.Sprintf("Received %d of %d records within %v", i, total, receiveTimeout))
+			break receive
+		}
+	}
+	for i, client := range recordedClients {
+		wg.Add(1)
+		go func(i int, client *checker.Client) {
+			defer wg.Done()
+			for gsn := from + int32(i); gsn < from+total; gsn += int32(clients) {
+				if _, err := client.Read(gsn); err != nil {
+					fail("read", gsn, err.Error())
+				}
+			}
+		}(i, client)
+	}
+	wg.Wait()
+	trimGsn := from + total/2
+	err = recordedClients[0].Trim(trimGsn)
+	if err != nil {
+		return err
+	}
+	for gsn := from; gsn < from+total; gsn++ {
+		_, err := recordedClients[len(recordedClients)-1].Read(gsn)
+		if err != nil && (gsn >= trimGsn || !errors.Is(err, clientlib.ErrRecordTrimmed)) {
+			fail("read", gsn, err.Error())
+		}
+	}
+	if path != "" {
+		err = recorder.WriteFile(path)
+		if err != nil {
+			return err
+		}
+	}
+	violations := append(failures, checker.Check(recorder.History())...)
+	if len(violations) > 0 {
+		messages := make([]string, len(violations))
+		for i, violation := range violations {
+			messages[i] = violation.String()
+		}
+		return fmt.Errorf("History inconsistent:\n%s", strings.Join(messages, "\n"))
+	}
+	fmt.Println("Test completed successfully")
+	return nil
+}